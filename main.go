@@ -1,199 +1,215 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/pion/webrtc/v4"
-)
-
-type PeerType int
 
-const (
-	PeerTypeHost  PeerType = 0
-	PeerTypeGuest PeerType = 1
+	"meetupstation/meetupstation-pion/station"
 )
 
-type MediaType int
+// A flag that can be given several times.
+type stringList []string
 
-const (
-	MediaTypeAudio MediaType = 0
-	MediaTypeVideo MediaType = 1
-)
+func (list *stringList) String() string {
+	return strings.Join(*list, ", ")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
 
 func main() {
-	if len(os.Args) != 4 ||
-		(os.Args[1] != "host" && os.Args[1] != "guest") {
+	var options station.Options
+	var direction string
+	var media string
+	var videoLayerSpecs stringList
+	var extraVideoSourceSpecs stringList
+	var audioSinkSpecs stringList
+	var videoSinkSpecs stringList
+	var extraVideoSinkSpecs stringList
+	var pinnedFingerprints stringList
+
+	flag.StringVar(&options.Signalling.Token, "token", "",
+		"bearer token for the signalling server (default $MEETUPSTATION_TOKEN)")
+	flag.StringVar(&options.Signalling.HMACKey, "hmac-key", "",
+		"key to sign signalling requests with HMAC-SHA256 (default $MEETUPSTATION_HMAC_KEY)")
+	flag.StringVar(&options.Signalling.CAFile, "ca-file", "",
+		"PEM bundle of extra certificate authorities for the signalling server")
+	flag.StringVar(&options.Signalling.CertFile, "cert-file", "",
+		"PEM client certificate for the signalling server")
+	flag.StringVar(&options.Signalling.KeyFile, "key-file", "",
+		"PEM private key of the client certificate")
+	flag.StringVar(&options.Signalling.RoomSecret, "room-secret", "",
+		"secret shared by host and guest to seal descriptions end to end (default $MEETUPSTATION_ROOM_SECRET)")
+	flag.BoolVar(&options.Signalling.AllowInsecure, "allow-insecure", false,
+		"allow the host id and credentials to be sent over plain http://")
+
+	flag.StringVar(&options.CertificatePath, "certificate", "",
+		"PEM file with the station DTLS certificate, generated when missing")
+	flag.Var(&pinnedFingerprints, "pin-fingerprint",
+		"accept only a remote with this DTLS fingerprint, like \"sha-256 AB:CD:...\" (repeatable)")
+
+	flag.StringVar(&direction, "direction", "sendrecv",
+		"sendrecv, sendonly for a camera that takes no media from the peers, or recvonly for a viewer without local media")
+
+	flag.StringVar(&media, "media", "audio,video",
+		"the media of the sessions: audio,video, audio for a podcast or video")
+
+	flag.StringVar(&options.AudioSource, "audio-source", "udp:4000",
+		"local audio: udp:[host:]port, file:path.ogg or test")
+	flag.StringVar(&options.VideoSource, "video-source", "udp:4002",
+		"local video: udp:[host:]port, file:path.ivf, file:path.h264 or test")
+	flag.Var(&videoLayerSpecs, "video-layer",
+		"an encoding of the local video as rid=source, best first, each peer gets the best one its bandwidth allows (repeatable, replaces -video-source)")
+	flag.Var(&extraVideoSourceSpecs, "extra-video-source",
+		"another local video as name=source, like screen=udp:4008, sent as a track with the name as its id (repeatable)")
+	flag.IntVar(&options.H264FrameRate, "h264-fps", 30,
+		"frame rate of file:path.h264 video sources")
+	flag.StringVar(&options.StreamID, "stream-id", "pion",
+		"id of the media stream of the local tracks, as the browsers see it")
+	flag.StringVar(&options.AudioTrackID, "audio-track-id", "audio",
+		"id of the local audio track")
+	flag.StringVar(&options.VideoTrackID, "video-track-id", "video",
+		"id of the local video track")
+	flag.BoolVar(&options.SharedTracks, "shared-tracks", false,
+		"bind the same local tracks to every peer and write each packet once, without -video-layer")
+	flag.StringVar(&options.BitrateFeedback, "bitrate-feedback", "",
+		"tell the local encoder the bitrate the peers can take each second, as JSON: udp:[host:]port or file:path")
+
+	flag.Var(&audioSinkSpecs, "audio-sink",
+		"remote audio: udp:[host:]port, file:path.ogg or null (repeatable, default udp:4004)")
+	flag.Var(&videoSinkSpecs, "video-sink",
+		"remote video: udp:[host:]port, file:path.h264, file:path.ivf or null (repeatable, default udp:4006)")
+
+	flag.StringVar(&options.EgressSDP, "egress-sdp", "",
+		"keep an SDP file of the udp sinks at this path for ffmpeg or gstreamer")
+	flag.Var(&extraVideoSinkSpecs, "extra-video-sink",
+		"where a remote video goes, as name=sink with a track id, id:, stream: or rid: as the name, like screen=udp:4010 (repeatable)")
+	flag.DurationVar(&options.JitterBufferLatency, "jitter-buffer", 0,
+		"hold the remote media up to this long to reorder it and wait for retransmissions, like 150ms")
+
+	flag.StringVar(&options.OnAirPolicy, "on-air", station.OnAirNewest,
+		"which guest the sinks get when one joins: newest, or first to keep the one on air until it leaves")
+	flag.BoolVar(&options.OnAirRequests, "on-air-requests", false,
+		"let a guest go on air by sending {\"type\": \"on-air\"} over the data channel")
+
+	flag.StringVar(&options.RTSPAddress, "rtsp", "",
+		"serve the remote media as rtsp://<address>/guest, like 127.0.0.1:8554")
+
+	flag.StringVar(&options.HTTPAddress, "http", "",
+		"serve the WHIP endpoint /whip, the WHEP endpoint /whep and /metrics on this address, like 127.0.0.1:8080")
+	flag.DurationVar(&options.SlowPeerTimeout, "slow-peer-timeout", 10*time.Second,
+		"disconnect a peer that can not keep up with the local media for this long, 0 never does")
+	flag.StringVar(&options.WHIPToken, "whip-token", "",
+		"bearer token WHIP publishers have to send (default $MEETUPSTATION_WHIP_TOKEN)")
+	flag.StringVar(&options.WHEPToken, "whep-token", "",
+		"bearer token WHEP players have to send (default $MEETUPSTATION_WHEP_TOKEN)")
+
+	flag.StringVar(&options.ControlAddress, "control", "",
+		"serve the control API, to list and kick peers, pick the on-air guest, mute tracks and change the host id, on unix:path or a loopback address like 127.0.0.1:8081")
+	flag.BoolVar(&options.ControlAllowRemote, "control-allow-remote", false,
+		"let -control listen on an address other than a loopback one, the API has no authentication")
+
+	flag.StringVar(&options.Webhook, "webhook", "",
+		"POST the peer-joined, peer-left, ice-state-changed, track-started, data-message and signalling-error events as JSON to this URL")
+	flag.StringVar(&options.WebhookToken, "webhook-token", "",
+		"bearer token sent to the webhook (default $MEETUPSTATION_WEBHOOK_TOKEN)")
+
+	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr,
-			"example usage: ./meetupstation-pion [host,guest] https://meetupstation.com \"secret host room id\"\n")
-		return
+			"example usage: ./meetupstation-pion [options] [host,guest] https://meetupstation.com \"secret host room id\"\n"+
+				"               ./meetupstation-pion -http 127.0.0.1:8080 [options]\n")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	var peerType PeerType
-
-	switch os.Args[1] {
-	case "host":
-		peerType = PeerTypeHost
-	case "guest":
-		peerType = PeerTypeGuest
+	// read secrets from the environment after parsing, so that the
+	// usage message never prints them as defaults
+	if options.Signalling.Token == "" {
+		options.Signalling.Token = os.Getenv("MEETUPSTATION_TOKEN")
+	}
+	if options.Signalling.HMACKey == "" {
+		options.Signalling.HMACKey = os.Getenv("MEETUPSTATION_HMAC_KEY")
+	}
+	if options.Signalling.RoomSecret == "" {
+		options.Signalling.RoomSecret = os.Getenv("MEETUPSTATION_ROOM_SECRET")
+	}
+	if options.WHIPToken == "" {
+		options.WHIPToken = os.Getenv("MEETUPSTATION_WHIP_TOKEN")
+	}
+	if options.WHEPToken == "" {
+		options.WHEPToken = os.Getenv("MEETUPSTATION_WHEP_TOKEN")
+	}
+	if options.WebhookToken == "" {
+		options.WebhookToken = os.Getenv("MEETUPSTATION_WEBHOOK_TOKEN")
 	}
 
-	signalServer := os.Args[2]
-	hostId := os.Args[3]
-	// signalServer := "https://meetupstation.com"
-	// hostId := "secret host room id"
-	// peerType := PeerTypeHost
-
-	var peers []Peer
-
-	interruptChannel := make(chan os.Signal, 1)
-	signal.Notify(interruptChannel, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-interruptChannel
-		os.Exit(0)
-	}()
-
-	var mutex sync.Mutex
-
-	go streamLocalTrack(&peers, MediaTypeAudio, 4000)
-	go streamLocalTrack(&peers, MediaTypeVideo, 4002)
-
-	for {
-		fmt.Fprintf(os.Stderr, "starting a new peer connection...\n")
-
-		var err error
-		peerIndex, connectedChannel := newPeerConnection(&peers, &mutex)
-
-		var localSessionDescription webrtc.SessionDescription
-
-		mutex.Lock()
-		fmt.Fprintf(os.Stderr, "conn %d: setting up tracks and data handlers\n", peerIndex)
-		setupTracksAndDataHandlers(&peers, peerIndex)
-		mutex.Unlock()
-
-		if peerType == PeerTypeHost {
-			for {
-				mutex.Lock()
-				offerSessionDescription, err := peers[peerIndex].peerConnection.CreateOffer(nil)
-				mutex.Unlock()
-
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "while creating offer: %s\n", err)
-					continue
-				}
-				localSessionDescription = offerSessionDescription
-				break
-			}
-		} else {
-			hostOffer := signalWaitForHost(signalServer, hostId, peerIndex)
-
-			for {
-				mutex.Lock()
-				err = peers[peerIndex].peerConnection.SetRemoteDescription(hostOffer)
-				mutex.Unlock()
-
-				if err != nil {
-					fmt.Fprintf(os.Stderr,
-						"while setting remote description: %s\n",
-						err)
-					continue
-				}
-				break
-			}
-
-			for {
-				mutex.Lock()
-				answerSessionDescription, err := peers[peerIndex].peerConnection.CreateAnswer(nil)
-				mutex.Unlock()
-
-				if err != nil {
-					fmt.Fprintf(os.Stderr,
-						"while creating answer: %s\n",
-						err)
-					continue
-				}
-				localSessionDescription = answerSessionDescription
-				break
-			}
-		}
-
-		// later will be locking untill this channel completes
-		mutex.Lock()
-		waitForAllICECandidates := webrtc.GatheringCompletePromise(peers[peerIndex].peerConnection)
-		mutex.Unlock()
-
-		for {
-			mutex.Lock()
-			err = peers[peerIndex].peerConnection.SetLocalDescription(localSessionDescription)
-			mutex.Unlock()
-
-			if err != nil {
-				fmt.Fprintf(os.Stderr,
-					"while setting local description: %s\n",
-					err)
-				continue
-			}
-			break
-		}
-
-		fmt.Fprintf(os.Stderr, "conn %d: waiting for all ice candidates\n", peerIndex)
-		<-waitForAllICECandidates
-
-		fmt.Fprintf(os.Stderr, "conn %d: all ice candidates are received from stun server\n", peerIndex)
-
-		var peerLocalSessionDescription *webrtc.SessionDescription
-		mutex.Lock()
-		peerLocalSessionDescription = peers[peerIndex].peerConnection.LocalDescription()
-		mutex.Unlock()
+	// without a room the station only serves its http endpoints
+	signalling := flag.NArg() != 0
 
-		if peerType == PeerTypeHost {
+	options.Direction = webrtc.NewRTPTransceiverDirection(direction)
 
-			fmt.Fprintf(os.Stderr, "conn %d: waiting for the signalling settlement\n", peerIndex)
+	for _, kind := range strings.Split(media, ",") {
+		switch strings.TrimSpace(kind) {
+		case "audio":
+			options.Audio = true
+		case "video":
+			options.Video = true
+		default:
+			options.Audio, options.Video = false, false
+		}
+	}
 
-			guestAnswer := signalWaitForGuest(signalServer,
-				hostId,
-				peerIndex,
-				*peerLocalSessionDescription)
+	if (signalling && flag.NArg() != 3) ||
+		(signalling && flag.Arg(0) != "host" && flag.Arg(0) != "guest") ||
+		(!signalling && options.HTTPAddress == "") ||
+		(!options.Audio && !options.Video) ||
+		options.Direction == webrtc.RTPTransceiverDirectionUnknown ||
+		options.Direction == webrtc.RTPTransceiverDirectionInactive {
+		flag.Usage()
+		return
+	}
 
-			// debug logging
-			fmt.Fprintf(os.Stderr, "conn %d: setting the remote description\n", peerIndex)
+	switch flag.Arg(0) {
+	case "host":
+		options.PeerType = station.PeerTypeHost
+	case "guest":
+		options.PeerType = station.PeerTypeGuest
+	}
 
-			mutex.Lock()
-			peers[peerIndex].peerConnection.SetRemoteDescription(guestAnswer)
-			mutex.Unlock()
+	options.SignalServer = flag.Arg(1)
+	options.HostID = flag.Arg(2)
+	// options.SignalServer = "https://meetupstation.com"
+	// options.HostID = "secret host room id"
+	// options.PeerType = station.PeerTypeHost
+
+	options.PinnedFingerprints = pinnedFingerprints
+	options.VideoLayers = videoLayerSpecs
+	options.ExtraVideoSources = extraVideoSourceSpecs
+	options.AudioSinks = audioSinkSpecs
+	options.VideoSinks = videoSinkSpecs
+	options.ExtraVideoSinks = extraVideoSinkSpecs
+
+	meetupStation, err := station.New(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 
-			// debug logging
-			fmt.Fprintf(os.Stderr, "conn %d: have set the remote description\n", peerIndex)
-		} else {
-			signalGuestSetup(signalServer,
-				hostId,
-				*peerLocalSessionDescription,
-				peerIndex)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		fmt.Fprintf(os.Stderr, "conn %d: signalling settled: waiting for the ice connection\n", peerIndex)
-
-		select {
-		case connected := <-connectedChannel:
-			if connected {
-				fmt.Fprintf(os.Stderr, "conn %d: ice connected\n", peerIndex)
-				if peerType == PeerTypeGuest {
-					connected = <-connectedChannel
-				}
-			}
-
-			if !connected {
-				fmt.Fprintf(os.Stderr, "conn %d: ice disconnected\n", peerIndex)
-			}
-		case <-time.After(30 * time.Second):
-			fmt.Fprintf(os.Stderr, "conn %d: timeout waiting for ice event\n", peerIndex)
-			mutex.Lock()
-			peers[peerIndex].Close(peerIndex)
-			mutex.Unlock()
-		}
+	if err = meetupStation.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
 }
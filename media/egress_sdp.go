@@ -0,0 +1,183 @@
+package media
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// What the "udp" sinks send until a guest tells otherwise, as in
+// remote.sdp.
+var (
+	DefaultEgressAudioCodec = webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		},
+	}
+	DefaultEgressVideoCodec = webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "packetization-mode=1",
+		},
+	}
+)
+
+type egressMedia struct {
+	mediaType   Type
+	address     string
+	payloadType uint8
+	codec       webrtc.RTPCodecParameters
+	// the remote track on air, empty before the first one
+	label string
+}
+
+// Keeps an SDP file like remote.sdp up to date for ffmpeg or gstreamer: a
+// media section for every "udp" sink of the media of the station, with the
+// codec of the guest on air and the track it comes from as the title.
+type EgressDescription struct {
+	path string
+
+	mutex sync.Mutex
+	media map[string]egressMedia
+}
+
+func NewEgressDescription(path string) *EgressDescription {
+	return &EgressDescription{path: path, media: map[string]egressMedia{}}
+}
+
+// Set the codec going to address and write the file again.
+func (description *EgressDescription) describe(mediaType Type,
+	address string,
+	payloadType uint8,
+	codec webrtc.RTPCodecParameters) error {
+
+	if description == nil {
+		return nil
+	}
+
+	description.mutex.Lock()
+	defer description.mutex.Unlock()
+
+	media := egressMedia{mediaType, address, payloadType, codec, ""}
+	previous, found := description.media[address]
+	if found &&
+		previous.codec.MimeType == codec.MimeType &&
+		previous.codec.SDPFmtpLine == codec.SDPFmtpLine {
+		return nil
+	}
+	media.label = previous.label
+	description.media[address] = media
+
+	return ReplaceFile(description.path, []byte(description.sdp()))
+}
+
+// Set the remote track going to address, after describe.
+func (description *EgressDescription) label(address string, label string) error {
+	if description == nil {
+		return nil
+	}
+
+	description.mutex.Lock()
+	defer description.mutex.Unlock()
+
+	media, found := description.media[address]
+	if !found || media.label == label {
+		return nil
+	}
+	media.label = label
+	description.media[address] = media
+
+	return ReplaceFile(description.path, []byte(description.sdp()))
+}
+
+// The mutex is held.
+func (description *EgressDescription) sdp() string {
+	all := make([]egressMedia, 0, len(description.media))
+	for _, media := range description.media {
+		all = append(all, media)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].mediaType != all[j].mediaType {
+			return all[i].mediaType < all[j].mediaType
+		}
+		return all[i].address < all[j].address
+	})
+
+	var sdp strings.Builder
+	sdp.WriteString("v=0\n")
+	sdp.WriteString("o=- 0 0 IN IP4 127.0.0.1\n")
+	sdp.WriteString("s=meetupstation-pion\n")
+	sdp.WriteString("t=0 0\n")
+
+	for _, media := range all {
+		host, port, err := net.SplitHostPort(media.address)
+		if err != nil {
+			panic(fmt.Sprintf("logic: net.SplitHostPort of a udp sink - %s", err))
+		}
+
+		kind, encoding, _ := strings.Cut(media.codec.MimeType, "/")
+		rtpmap := fmt.Sprintf("%s/%d", strings.ToUpper(encoding), media.codec.ClockRate)
+		if media.codec.Channels > 0 {
+			rtpmap += fmt.Sprintf("/%d", media.codec.Channels)
+		}
+
+		fmt.Fprintf(&sdp, "m=%s %s RTP/AVP %d\n", kind, port, media.payloadType)
+		if media.label != "" {
+			fmt.Fprintf(&sdp, "i=%s\n", media.label)
+		}
+		fmt.Fprintf(&sdp, "c=IN IP4 %s\n", host)
+		fmt.Fprintf(&sdp, "a=rtpmap:%d %s\n", media.payloadType, rtpmap)
+		if media.codec.SDPFmtpLine != "" {
+			fmt.Fprintf(&sdp, "a=fmtp:%d %s\n", media.payloadType, media.codec.SDPFmtpLine)
+		}
+	}
+
+	return sdp.String()
+}
+
+// Describe the "udp" sinks among specs with codec.
+func DescribeUDPSinks(description *EgressDescription,
+	mediaType Type,
+	specs []string,
+	codec webrtc.RTPCodecParameters) {
+
+	for _, spec := range specs {
+		kind, argument, _ := strings.Cut(spec, ":")
+		if kind != "udp" {
+			continue
+		}
+
+		address, payloadType := udpSinkTarget(mediaType, argument)
+		if err := description.describe(mediaType, address, payloadType, codec); err != nil {
+			fmt.Fprintf(os.Stderr, "egress sdp: %s\n", err)
+		}
+	}
+}
+
+// Write through a temporary file, so that a reader never sees half of it.
+func ReplaceFile(path string, data []byte) error {
+	temporary, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = temporary.Write(data)
+	if closeErr := temporary.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+
+	return os.Rename(temporary.Name(), path)
+}
@@ -0,0 +1,177 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// a jump this far is a new stream, not a gap
+	jitterBufferMaxPackets  = 1000
+	jitterBufferLogInterval = 10 * time.Second
+)
+
+// Puts the packets of a remote track back in order before they reach the
+// sinks. Packets in order go through right away; after a gap the buffer
+// holds the packets behind it for up to latency, while the NACK generator
+// of the pion default interceptors asks the peer to send the missing ones
+// again. Then the gap is given up and packets of it that still come are
+// dropped as late.
+type JitterBuffer struct {
+	peerIndex int
+	kind      string
+	latency   time.Duration
+
+	started  bool
+	next     uint16
+	packets  map[uint16]*rtp.Packet
+	gapSince time.Time
+
+	lost    int
+	late    int
+	lastLog time.Time
+}
+
+func NewJitterBuffer(peerIndex int, kind string, latency time.Duration) *JitterBuffer {
+	return &JitterBuffer{
+		peerIndex: peerIndex,
+		kind:      kind,
+		latency:   latency,
+		packets:   map[uint16]*rtp.Packet{},
+		lastLog:   time.Now(),
+	}
+}
+
+// Add a packet read from the track, returns the packets that can go to the
+// sinks, in order.
+func (buffer *JitterBuffer) Push(packet *rtp.Packet) []*rtp.Packet {
+	now := time.Now()
+	defer buffer.log(now)
+
+	if !buffer.started {
+		buffer.started = true
+		buffer.next = packet.SequenceNumber
+	}
+
+	distance := int16(packet.SequenceNumber - buffer.next)
+	switch {
+	case distance < 0 && -int(distance) < jitterBufferMaxPackets:
+		buffer.late++
+		return nil
+	case distance < 0 || int(distance) >= jitterBufferMaxPackets:
+		// the sender started over, let go of everything held
+		released := buffer.flush()
+		buffer.next = packet.SequenceNumber
+		buffer.packets[packet.SequenceNumber] = packet
+		return append(released, buffer.release(now)...)
+	}
+
+	if _, found := buffer.packets[packet.SequenceNumber]; found {
+		return nil
+	}
+	buffer.packets[packet.SequenceNumber] = packet
+
+	return buffer.release(now)
+}
+
+// When the packets held behind a gap are due, zero while nothing is held.
+// The track has to be read with this deadline, so that the packets go on
+// at latency even when the peer sends nothing after the gap.
+func (buffer *JitterBuffer) Deadline() time.Time {
+	if buffer.gapSince.IsZero() {
+		return time.Time{}
+	}
+
+	return buffer.gapSince.Add(buffer.latency)
+}
+
+// The packets that can go to the sinks at now without a new one, after a
+// read of the track timed out at the deadline.
+func (buffer *JitterBuffer) Release(now time.Time) []*rtp.Packet {
+	defer buffer.log(now)
+
+	return buffer.release(now)
+}
+
+func (buffer *JitterBuffer) release(now time.Time) []*rtp.Packet {
+	var released []*rtp.Packet
+
+	for len(buffer.packets) > 0 {
+		if packet, found := buffer.packets[buffer.next]; found {
+			released = append(released, packet)
+			delete(buffer.packets, buffer.next)
+			buffer.next++
+			buffer.gapSince = time.Time{}
+			continue
+		}
+
+		if buffer.gapSince.IsZero() {
+			buffer.gapSince = now
+		}
+		if now.Sub(buffer.gapSince) < buffer.latency {
+			break
+		}
+
+		// give up on the gap, go on with the first packet after it
+		skip := buffer.firstHeld()
+		buffer.lost += int(skip - buffer.next)
+		buffer.next = skip
+		buffer.gapSince = time.Time{}
+	}
+
+	return released
+}
+
+// All the packets held, in order, whatever is missing.
+func (buffer *JitterBuffer) flush() []*rtp.Packet {
+	var released []*rtp.Packet
+
+	for len(buffer.packets) > 0 {
+		skip := buffer.firstHeld()
+		buffer.lost += int(skip - buffer.next)
+		released = append(released, buffer.packets[skip])
+		delete(buffer.packets, skip)
+		buffer.next = skip + 1
+	}
+	buffer.gapSince = time.Time{}
+
+	return released
+}
+
+func (buffer *JitterBuffer) firstHeld() uint16 {
+	first := buffer.next
+	firstDistance := -1
+
+	for sequenceNumber := range buffer.packets {
+		distance := int(sequenceNumber - buffer.next)
+		if firstDistance == -1 || distance < firstDistance {
+			first = sequenceNumber
+			firstDistance = distance
+		}
+	}
+
+	return first
+}
+
+func (buffer *JitterBuffer) log(now time.Time) {
+	if now.Sub(buffer.lastLog) < jitterBufferLogInterval {
+		return
+	}
+	buffer.lastLog = now
+
+	if buffer.lost == 0 && buffer.late == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr,
+		"conn %d: %s jitter buffer - %d packets lost, %d late\n",
+		buffer.peerIndex,
+		buffer.kind,
+		buffer.lost,
+		buffer.late)
+	buffer.lost = 0
+	buffer.late = 0
+}
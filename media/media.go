@@ -0,0 +1,11 @@
+// Package media moves RTP between the station and the programs around it:
+// the sources of the local tracks, the sinks of the remote ones and the
+// streams that keep the sinks continuous from guest to guest.
+package media
+
+type Type int
+
+const (
+	Audio Type = 0
+	Video Type = 1
+)
@@ -0,0 +1,291 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264writer"
+	"github.com/pion/webrtc/v4/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+// Where the media of a remote track goes. A peer can have several sinks
+// per kind, every packet of the track is written to all of them.
+//
+// Sinks must not change the packets, they are shared between the sinks.
+type Sink interface {
+	// called with the codec of the remote track before its first packet
+	Bind(codec webrtc.RTPCodecParameters) error
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// Implemented by sinks that need a keyframe at times, like when a player
+// joins in the middle of the stream.
+type KeyframeRequester interface {
+	OnKeyframeNeeded(requestKeyframe func())
+}
+
+// Implemented by sinks that tell their consumers which remote track they
+// carry.
+type TrackLabeler interface {
+	LabelTrack(label string)
+}
+
+// What the sinks of all the peers share.
+type SinkOptions struct {
+	// serves the "rtsp" sinks, nil when disabled
+	RTSPServer *RTSPServer
+	// the streams of the "udp" sinks, they go on from guest to guest
+	EgressStreams *EgressStreams
+	// SDP of the "udp" sinks for their consumers, nil when not wanted
+	EgressDescription *EgressDescription
+}
+
+// Open a media sink from the command line for one peer:
+//
+//	udp:4006, udp:127.0.0.1:4006 - raw RTP for ffmpeg or gstreamer
+//	file:guest-{peer}.h264, .ivf or .ogg - a recording, {peer} is
+//	replaced by the peer index
+//	rtsp - the guest path of the embedded RTSP server
+//	null - drops the media
+func NewSink(mediaType Type, spec string, peerIndex int, options SinkOptions) (Sink, error) {
+	kind, argument, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "udp":
+		address, payloadType := udpSinkTarget(mediaType, argument)
+
+		// udp:4006 and udp:127.0.0.1:4006 are the same consumer
+		remoteAddress, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return nil, err
+		}
+
+		// one stream for all the guests, the consumer keeps running
+		return newUDPSink(mediaType,
+			address,
+			payloadType,
+			options.EgressStreams.get(remoteAddress.String()),
+			options.EgressDescription)
+	case "file":
+		return newFileSink(
+			strings.ReplaceAll(argument, "{peer}", strconv.Itoa(peerIndex))), nil
+	case "rtsp":
+		if options.RTSPServer == nil {
+			return nil, errors.New("the rtsp server is not running")
+		}
+		return newRTSPSink(options.RTSPServer, mediaType, RTSPGuestPath), nil
+	case "null":
+		return &nullSink{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown media sink %q", spec)
+}
+
+// The address and payload type of udp:[host:]port.
+func udpSinkTarget(mediaType Type, argument string) (string, uint8) {
+	// the payload types of remote.sdp
+	var payloadType uint8 = 111
+	if mediaType == Video {
+		payloadType = 96
+	}
+
+	if !strings.Contains(argument, ":") {
+		argument = "127.0.0.1:" + argument
+	}
+
+	return argument, payloadType
+}
+
+// Raw RTP to a local UDP port. The payload type is rewritten to the one
+// the consumer expects, and the SSRC, sequence numbers and timestamps to
+// the ones of stream, which goes on when the next guest takes over.
+type udpSink struct {
+	mediaType   Type
+	address     string
+	connection  *net.UDPConn
+	payloadType uint8
+	stream      *RTPRewriter
+	description *EgressDescription
+	buffer      []byte
+}
+
+func newUDPSink(mediaType Type,
+	address string,
+	payloadType uint8,
+	stream *RTPRewriter,
+	description *EgressDescription) (*udpSink, error) {
+
+	localAddress, err := net.ResolveUDPAddr("udp", "127.0.0.1:")
+	if err != nil {
+		panic(fmt.Sprintf("logic: net.ResolveUDPAddr for local - %s", err))
+	}
+
+	remoteAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := net.DialUDP("udp", localAddress, remoteAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpSink{
+		mediaType:   mediaType,
+		address:     address,
+		connection:  connection,
+		payloadType: payloadType,
+		stream:      stream,
+		description: description,
+		buffer:      make([]byte, 1500),
+	}, nil
+}
+
+// The stream goes on with the track of this sink, the guest on air.
+func (sink *udpSink) Bind(codec webrtc.RTPCodecParameters) error {
+	sink.stream.SetClockRate(codec.ClockRate)
+	sink.stream.SwitchTo(sink)
+
+	// the consumer can still play the other media when the file is stale
+	err := sink.description.describe(sink.mediaType, sink.address, sink.payloadType, codec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "egress sdp: %s\n", err)
+	}
+
+	return nil
+}
+
+func (sink *udpSink) LabelTrack(label string) {
+	if err := sink.description.label(sink.address, label); err != nil {
+		fmt.Fprintf(os.Stderr, "egress sdp: %s\n", err)
+	}
+}
+
+func (sink *udpSink) WriteRTP(packet *rtp.Packet) error {
+	var outboundPacket rtp.Packet
+	if !sink.stream.Rewrite(sink, packet, &outboundPacket) {
+		// an older guest, which is off the air
+		return nil
+	}
+
+	outboundPacket.PayloadType = sink.payloadType
+
+	n, err := outboundPacket.MarshalTo(sink.buffer)
+	if err != nil {
+		return err
+	}
+
+	_, err = sink.connection.Write(sink.buffer[:n])
+	if err != nil {
+		// nobody is listening on the port yet
+		var opError *net.OpError
+		if errors.As(err, &opError) &&
+			opError.Err.Error() == "write: connection refused" {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (sink *udpSink) Close() error {
+	sink.stream.Release(sink)
+	return sink.connection.Close()
+}
+
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// A recording of the track. The container follows the file extension, the
+// file is created when the track starts. A track bound again with the same
+// codec, like a guest back on air, goes on in the same file.
+type fileSink struct {
+	path   string
+	codec  webrtc.RTPCodecParameters
+	writer rtpWriter
+}
+
+func newFileSink(path string) *fileSink {
+	return &fileSink{path: path}
+}
+
+func (sink *fileSink) Bind(codec webrtc.RTPCodecParameters) error {
+	if sink.writer != nil {
+		if strings.EqualFold(codec.MimeType, sink.codec.MimeType) &&
+			codec.ClockRate == sink.codec.ClockRate {
+			return nil
+		}
+		return fmt.Errorf("file sink is already bound to %s", sink.codec.MimeType)
+	}
+
+	extension := strings.ToLower(filepath.Ext(sink.path))
+	mimeType := strings.ToLower(codec.MimeType)
+
+	switch {
+	case extension == ".h264" && mimeType == strings.ToLower(webrtc.MimeTypeH264):
+		writer, err := h264writer.New(sink.path)
+		if err != nil {
+			return err
+		}
+		sink.writer = writer
+	case extension == ".ivf":
+		writer, err := ivfwriter.New(sink.path, ivfwriter.WithCodec(codec.MimeType))
+		if err != nil {
+			return err
+		}
+		sink.writer = writer
+	case extension == ".ogg" && mimeType == strings.ToLower(webrtc.MimeTypeOpus):
+		writer, err := oggwriter.New(sink.path, codec.ClockRate, codec.Channels)
+		if err != nil {
+			return err
+		}
+		sink.writer = writer
+	default:
+		return fmt.Errorf("can not record %s into %s", codec.MimeType, sink.path)
+	}
+	sink.codec = codec
+
+	return nil
+}
+
+func (sink *fileSink) WriteRTP(packet *rtp.Packet) error {
+	if sink.writer == nil {
+		return errors.New("file sink is not bound")
+	}
+
+	return sink.writer.WriteRTP(packet)
+}
+
+func (sink *fileSink) Close() error {
+	if sink.writer == nil {
+		return nil
+	}
+
+	return sink.writer.Close()
+}
+
+// Drops everything, for testing.
+type nullSink struct{}
+
+func (sink *nullSink) Bind(codec webrtc.RTPCodecParameters) error {
+	return nil
+}
+
+func (sink *nullSink) WriteRTP(packet *rtp.Packet) error {
+	return nil
+}
+
+func (sink *nullSink) Close() error {
+	return nil
+}
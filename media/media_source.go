@@ -0,0 +1,487 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/h264reader"
+	"github.com/pion/webrtc/v4/pkg/media/ivfreader"
+)
+
+// Where the local media comes from. The station forwards every packet
+// to the local tracks of all peers.
+type Source interface {
+	// the codec of the packets, the local tracks are created with it
+	Codec() webrtc.RTPCodecCapability
+	// blocks until the next packet is due
+	ReadRTP() (*rtp.Packet, error)
+	Close() error
+}
+
+// Parse a media source from the command line:
+//
+//	udp:4002, udp:127.0.0.1:4002 - raw RTP from an external encoder
+//	file:movie.ivf, file:voice.ogg, file:movie.h264 - a looping file
+//	test - a generated test pattern for video or silence for audio
+func NewSource(mediaType Type, spec string, h264FrameRate int) (Source, error) {
+	kind, argument, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "udp":
+		codec := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}
+		if mediaType == Video {
+			codec = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}
+		}
+
+		if !strings.Contains(argument, ":") {
+			argument = "127.0.0.1:" + argument
+		}
+
+		return newUDPSource(argument, codec)
+	case "file":
+		return newFileSource(mediaType, argument, h264FrameRate)
+	case "test":
+		if mediaType == Video {
+			return newFrameSource(newTestPatternFrames(testPatternFrameRate))
+		}
+
+		return newFrameSource(newSilenceFrames())
+	}
+
+	return nil, fmt.Errorf("unknown media source %q", spec)
+}
+
+// Raw RTP packets from a local UDP port, as sent by ffmpeg or gstreamer.
+type udpSource struct {
+	listener *net.UDPConn
+	codec    webrtc.RTPCodecCapability
+	buffer   []byte
+}
+
+func newUDPSource(address string, codec webrtc.RTPCodecCapability) (*udpSource, error) {
+	localAddress, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenUDP("udp", localAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// Increase the UDP receive buffer size
+	// Default UDP buffer sizes vary on different operating systems
+	bufferSize := 300000 // 300KB
+	err = listener.SetReadBuffer(bufferSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"listener.SetReadBuffer, %s\n",
+			err)
+	}
+
+	return &udpSource{
+		listener: listener,
+		codec:    codec,
+		buffer:   make([]byte, 1600), // UDP MTU
+	}, nil
+}
+
+func (source *udpSource) Codec() webrtc.RTPCodecCapability {
+	return source.codec
+}
+
+// The payload of the packet is in the buffer of the source, it is only
+// good until the next read.
+func (source *udpSource) ReadRTP() (*rtp.Packet, error) {
+	packet := &rtp.Packet{}
+	if err := source.ReadRTPInto(source.buffer, packet); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+func (source *udpSource) ReadRTPInto(buffer []byte, packet *rtp.Packet) error {
+	readBytes, _, err := source.listener.ReadFrom(buffer)
+	if err != nil {
+		return err
+	}
+
+	return packet.Unmarshal(buffer[:readBytes])
+}
+
+func (source *udpSource) Close() error {
+	return source.listener.Close()
+}
+
+// Frames of a stream with their presentation time from its start.
+type frameReader interface {
+	codec() webrtc.RTPCodecCapability
+	payloader() rtp.Payloader
+	// io.EOF after the last frame
+	nextFrame() ([]byte, time.Duration, error)
+	// start over from the first frame
+	rewind() error
+	close() error
+}
+
+// Packetizes frames and paces them in real time, looping the stream when
+// it ends. Timestamps follow the presentation times of the frames and keep
+// growing across loops.
+type frameSource struct {
+	frames     frameReader
+	packetizer rtp.Packetizer
+	clockRate  uint32
+
+	timestampBase uint32
+	start         time.Time
+	loopOffset    time.Duration
+	lastTime      time.Duration
+	lastDuration  time.Duration
+
+	pending []*rtp.Packet
+	// the generated streams never fail a read, Close has to end them
+	closed atomic.Bool
+}
+
+func newFrameSource(frames frameReader, err error) (*frameSource, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	clockRate := frames.codec().ClockRate
+
+	return &frameSource{
+		frames: frames,
+		packetizer: rtp.NewPacketizer(1200,
+			0, // the track sets the payload type
+			rand.Uint32(),
+			frames.payloader(),
+			rtp.NewRandomSequencer(),
+			clockRate),
+		clockRate:     clockRate,
+		timestampBase: rand.Uint32(),
+		start:         time.Now(),
+	}, nil
+}
+
+func (source *frameSource) Codec() webrtc.RTPCodecCapability {
+	return source.frames.codec()
+}
+
+func (source *frameSource) ReadRTP() (*rtp.Packet, error) {
+	if source.closed.Load() {
+		return nil, net.ErrClosed
+	}
+
+	for len(source.pending) == 0 {
+		frame, frameTime, err := source.frames.nextFrame()
+		if errors.Is(err, io.EOF) {
+			if err = source.frames.rewind(); err != nil {
+				return nil, err
+			}
+
+			// the first frame of the next loop follows the last one
+			source.loopOffset += source.lastTime + source.lastDuration
+			source.lastTime = 0
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if frameTime > source.lastTime {
+			source.lastDuration = frameTime - source.lastTime
+		}
+		source.lastTime = frameTime
+
+		presentationTime := source.loopOffset + frameTime
+		time.Sleep(time.Until(source.start.Add(presentationTime)))
+
+		timestamp := source.timestampBase +
+			uint32(int64(presentationTime)*int64(source.clockRate)/int64(time.Second))
+
+		source.pending = source.packetizer.Packetize(frame, 0)
+		for _, packet := range source.pending {
+			packet.Timestamp = timestamp
+		}
+	}
+
+	packet := source.pending[0]
+	source.pending = source.pending[1:]
+
+	return packet, nil
+}
+
+func (source *frameSource) Close() error {
+	if source.closed.Swap(true) {
+		return nil
+	}
+
+	return source.frames.close()
+}
+
+func newFileSource(mediaType Type, path string, h264FrameRate int) (Source, error) {
+	extension := strings.ToLower(filepath.Ext(path))
+
+	switch {
+	case mediaType == Video && extension == ".ivf":
+		return newFrameSource(newIVFFrames(path))
+	case mediaType == Video && (extension == ".h264" || extension == ".264"):
+		return newFrameSource(newH264Frames(path, h264FrameRate))
+	case mediaType == Audio && (extension == ".ogg" || extension == ".opus"):
+		return newFrameSource(newOggFrames(path))
+	}
+
+	return nil, fmt.Errorf("unsupported file %s for this track", path)
+}
+
+// VP8, VP9 or AV1 frames from an IVF file.
+type ivfFrames struct {
+	file        *os.File
+	reader      *ivfreader.IVFReader
+	header      *ivfreader.IVFFileHeader
+	codecParams webrtc.RTPCodecCapability
+}
+
+func newIVFFrames(path string) (*ivfFrames, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := &ivfFrames{file: file}
+	if err = frames.rewind(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	switch frames.header.FourCC {
+	case "VP80":
+		frames.codecParams = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}
+	case "VP90":
+		frames.codecParams = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP9, ClockRate: 90000}
+	case "AV01":
+		frames.codecParams = webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeAV1, ClockRate: 90000}
+	default:
+		file.Close()
+		return nil, fmt.Errorf("unsupported IVF codec %q", frames.header.FourCC)
+	}
+
+	return frames, nil
+}
+
+func (frames *ivfFrames) codec() webrtc.RTPCodecCapability {
+	return frames.codecParams
+}
+
+func (frames *ivfFrames) payloader() rtp.Payloader {
+	switch frames.codecParams.MimeType {
+	case webrtc.MimeTypeVP8:
+		return &codecs.VP8Payloader{EnablePictureID: true}
+	case webrtc.MimeTypeVP9:
+		return &codecs.VP9Payloader{}
+	}
+
+	return &codecs.AV1Payloader{}
+}
+
+func (frames *ivfFrames) nextFrame() ([]byte, time.Duration, error) {
+	frame, frameHeader, err := frames.reader.ParseNextFrame()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// ivfreader scales the pts by the inverse of the timebase, undo that
+	// before converting the pts to a duration
+	numerator := uint64(frames.header.TimebaseNumerator)
+	denominator := uint64(frames.header.TimebaseDenominator)
+	if numerator == 0 {
+		numerator = 1
+	}
+	pts := frameHeader.Timestamp * numerator / denominator
+
+	return frame, time.Duration(pts * numerator * uint64(time.Second) / denominator), nil
+}
+
+func (frames *ivfFrames) rewind() error {
+	if _, err := frames.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var err error
+	frames.reader, frames.header, err = ivfreader.NewWith(frames.file)
+
+	return err
+}
+
+func (frames *ivfFrames) close() error {
+	return frames.file.Close()
+}
+
+// Opus packets from an Ogg file, one per payload. The packets are timed by
+// their lengths, a page can hold several of them.
+type oggFrames struct {
+	file    *os.File
+	packets *oggPacketReader
+	// the packets read so far, the first two are the headers
+	packetIndex int
+	// 48kHz samples up to the next packet
+	samples uint64
+}
+
+func newOggFrames(path string) (*oggFrames, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := &oggFrames{file: file}
+	if err = frames.rewind(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+func (frames *oggFrames) codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+}
+
+func (frames *oggFrames) payloader() rtp.Payloader {
+	return &codecs.OpusPayloader{}
+}
+
+func (frames *oggFrames) nextFrame() ([]byte, time.Duration, error) {
+	for {
+		packet, err := frames.packets.next()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// OpusTags
+		frames.packetIndex++
+		if frames.packetIndex == 2 {
+			continue
+		}
+
+		packetTime := time.Duration(frames.samples * uint64(time.Second) / 48000)
+		frames.samples += opusPacketSamples(packet)
+
+		return packet, packetTime, nil
+	}
+}
+
+func (frames *oggFrames) rewind() error {
+	if _, err := frames.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	frames.packets = newOggPacketReader(frames.file)
+	frames.samples = 0
+
+	// the identification header
+	packet, err := frames.packets.next()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(string(packet), "OpusHead") {
+		return errors.New("not an Ogg Opus stream")
+	}
+	frames.packetIndex = 1
+
+	return nil
+}
+
+func (frames *oggFrames) close() error {
+	return frames.file.Close()
+}
+
+// NAL units from an H264 Annex-B file. The file carries no timing, so
+// pictures are paced at a fixed frame rate.
+type h264Frames struct {
+	file       *os.File
+	reader     *h264reader.H264Reader
+	frameRate  int
+	frameIndex int
+	sliceSeen  bool
+}
+
+func newH264Frames(path string, frameRate int) (*h264Frames, error) {
+	if frameRate <= 0 {
+		return nil, errors.New("h264 frame rate has to be positive")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := &h264Frames{file: file, frameRate: frameRate}
+	if err = frames.rewind(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return frames, nil
+}
+
+func (frames *h264Frames) codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
+}
+
+func (frames *h264Frames) payloader() rtp.Payloader {
+	return &codecs.H264Payloader{}
+}
+
+func (frames *h264Frames) nextFrame() ([]byte, time.Duration, error) {
+	nal, err := frames.reader.NextNAL()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	isSlice := nal.UnitType == h264reader.NalUnitTypeCodedSliceNonIdr ||
+		nal.UnitType == h264reader.NalUnitTypeCodedSliceIdr
+
+	// a slice with first_mb_in_slice 0, coded as a single 1 bit, starts
+	// the next picture
+	if isSlice && len(nal.Data) > 1 && nal.Data[1]&0x80 != 0 {
+		if frames.sliceSeen {
+			frames.frameIndex++
+		}
+		frames.sliceSeen = true
+	}
+
+	frameTime := time.Duration(frames.frameIndex) * time.Second / time.Duration(frames.frameRate)
+
+	return nal.Data, frameTime, nil
+}
+
+func (frames *h264Frames) rewind() error {
+	if _, err := frames.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var err error
+	frames.reader, err = h264reader.NewReader(frames.file)
+	frames.frameIndex = 0
+	frames.sliceSeen = false
+
+	return err
+}
+
+func (frames *h264Frames) close() error {
+	return frames.file.Close()
+}
@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	oggPageHeaderLength = 27
+	// the page continues the last packet of the page before
+	oggContinuedPacket = 0x01
+)
+
+var (
+	errOggCapturePattern = errors.New("ogg page without the OggS capture pattern")
+	errOggChecksum       = errors.New("ogg page checksum mismatch")
+)
+
+// The packets of an Ogg stream, as the segment tables of the pages cut
+// them: a page can hold several packets, and a packet can go on over
+// several pages. oggreader only has the pages.
+type oggPacketReader struct {
+	reader *bufio.Reader
+	// the packets of the last page, and the one that goes on in the next
+	packets [][]byte
+	partial []byte
+}
+
+func newOggPacketReader(reader io.Reader) *oggPacketReader {
+	return &oggPacketReader{reader: bufio.NewReader(reader)}
+}
+
+// The next packet, io.EOF after the last one.
+func (packets *oggPacketReader) next() ([]byte, error) {
+	for len(packets.packets) == 0 {
+		if err := packets.readPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	packet := packets.packets[0]
+	packets.packets = packets.packets[1:]
+
+	return packet, nil
+}
+
+func (packets *oggPacketReader) readPage() error {
+	header := make([]byte, oggPageHeaderLength)
+	if _, err := io.ReadFull(packets.reader, header); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+		return err
+	}
+	if string(header[:4]) != "OggS" {
+		return errOggCapturePattern
+	}
+
+	segmentTable := make([]byte, header[26])
+	if _, err := io.ReadFull(packets.reader, segmentTable); err != nil {
+		return err
+	}
+
+	payloadLength := 0
+	for _, lacing := range segmentTable {
+		payloadLength += int(lacing)
+	}
+	payload := make([]byte, payloadLength)
+	if _, err := io.ReadFull(packets.reader, payload); err != nil {
+		return err
+	}
+
+	checksum := binary.LittleEndian.Uint32(header[22:26])
+	binary.LittleEndian.PutUint32(header[22:26], 0)
+	if oggChecksum(header, segmentTable, payload) != checksum {
+		return errOggChecksum
+	}
+
+	// a packet left over from a page that was lost is no good
+	if header[5]&oggContinuedPacket == 0 {
+		packets.partial = nil
+	}
+
+	// a lacing value under 255 ends a packet, 255 says it goes on
+	offset := 0
+	for _, lacing := range segmentTable {
+		packets.partial = append(packets.partial, payload[offset:offset+int(lacing)]...)
+		offset += int(lacing)
+
+		if lacing < 255 {
+			packets.packets = append(packets.packets, packets.partial)
+			packets.partial = nil
+		}
+	}
+
+	return nil
+}
+
+// CRC-32 of a page with the checksum field zeroed, polynomial 0x04c11db7
+// without reflection, as in RFC 3533.
+var oggChecksumTable = func() [256]uint32 {
+	var table [256]uint32
+	for index := range table {
+		value := uint32(index) << 24
+		for bit := 0; bit < 8; bit++ {
+			if value&0x80000000 != 0 {
+				value = value<<1 ^ 0x04c11db7
+			} else {
+				value <<= 1
+			}
+		}
+		table[index] = value
+	}
+
+	return table
+}()
+
+func oggChecksum(parts ...[]byte) uint32 {
+	var checksum uint32
+	for _, part := range parts {
+		for _, value := range part {
+			checksum = checksum<<8 ^ oggChecksumTable[byte(checksum>>24)^value]
+		}
+	}
+
+	return checksum
+}
+
+// The length of an Opus packet in 48kHz samples, from its TOC byte and
+// frame count as in RFC 6716, 0 when it is malformed.
+func opusPacketSamples(packet []byte) uint64 {
+	if len(packet) == 0 {
+		return 0
+	}
+
+	toc := packet[0]
+	config := toc >> 3
+
+	// in 1/10 ms
+	var frameLength uint64
+	switch {
+	case config < 12: // SILK
+		frameLength = [4]uint64{100, 200, 400, 600}[config%4]
+	case config < 16: // hybrid
+		frameLength = [2]uint64{100, 200}[config%2]
+	default: // CELT
+		frameLength = [4]uint64{25, 50, 100, 200}[config%4]
+	}
+
+	var frames uint64
+	switch toc & 0x03 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = uint64(packet[1] & 0x3f)
+	}
+
+	return frames * frameLength * 48000 / 10000
+}
@@ -0,0 +1,128 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+// An Ogg page with the packets laced into it, the last one goes on in the
+// next page when open.
+func oggPage(headerType byte, granulePosition uint64, open bool, packets ...[]byte) []byte {
+	var segmentTable, payload []byte
+	for packetIndex, packet := range packets {
+		length := len(packet)
+		for ; length >= 255; length -= 255 {
+			segmentTable = append(segmentTable, 255)
+		}
+		if !open || packetIndex != len(packets)-1 {
+			segmentTable = append(segmentTable, byte(length))
+		}
+		payload = append(payload, packet...)
+	}
+
+	header := make([]byte, oggPageHeaderLength)
+	copy(header, "OggS")
+	header[5] = headerType
+	binary.LittleEndian.PutUint64(header[6:14], granulePosition)
+	header[26] = byte(len(segmentTable))
+	binary.LittleEndian.PutUint32(header[22:26], oggChecksum(header, segmentTable, payload))
+
+	return append(append(header, segmentTable...), payload...)
+}
+
+// 20 ms CELT frames, config 31, one frame each
+func opusPacket(fill byte, length int) []byte {
+	return append([]byte{31 << 3}, bytes.Repeat([]byte{fill}, length-1)...)
+}
+
+func TestOggFramesSplitPages(t *testing.T) {
+	long := opusPacket(3, 300)
+
+	var file []byte
+	file = append(file, oggPage(0x02, 0, false, []byte("OpusHead\x01\x02\x00\x00\x80\xbb\x00\x00\x00\x00\x00"))...)
+	file = append(file, oggPage(0, 0, false, []byte("OpusTags"))...)
+	// two packets and the start of the third in one page, libopus style
+	file = append(file, oggPage(0, 1920, true, opusPacket(1, 40), opusPacket(2, 50), long[:255])...)
+	file = append(file, oggPage(oggContinuedPacket, 2880, false, long[255:])...)
+
+	path := filepath.Join(t.TempDir(), "voice.ogg")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := newOggFrames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frames.close()
+
+	want := []struct {
+		packet    []byte
+		frameTime time.Duration
+	}{
+		{opusPacket(1, 40), 0},
+		{opusPacket(2, 50), 20 * time.Millisecond},
+		{long, 40 * time.Millisecond},
+	}
+	for _, want := range want {
+		frame, frameTime, err := frames.nextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame, want.packet) {
+			t.Errorf("frame of %d bytes starting %x, want %d bytes starting %x",
+				len(frame), frame[:2], len(want.packet), want.packet[:2])
+		}
+		if frameTime != want.frameTime {
+			t.Errorf("frame time %s, want %s", frameTime, want.frameTime)
+		}
+	}
+
+	if _, _, err := frames.nextFrame(); err == nil {
+		t.Error("a frame after the last packet")
+	}
+}
+
+// The pages of pion's writer, checksums included.
+func TestOggFramesReadOggWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "voice.ogg")
+	writer, err := oggwriter.New(path, 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sequence := uint16(0); sequence < 3; sequence++ {
+		err = writer.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{Version: 2, SequenceNumber: sequence, Timestamp: uint32(sequence) * 960},
+			Payload: opusPacket(byte(sequence), 20),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := newOggFrames(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frames.close()
+
+	for sequence := 0; sequence < 3; sequence++ {
+		frame, _, err := frames.nextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame, opusPacket(byte(sequence), 20)) {
+			t.Errorf("frame %d is %x", sequence, frame)
+		}
+	}
+}
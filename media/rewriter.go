@@ -0,0 +1,145 @@
+package media
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Turns the packets of several RTP streams, one after the other, into one
+// continuous stream: after a switch the sequence numbers go on where the
+// last stream stopped, and the timestamps by the time in between.
+type RTPRewriter struct {
+	mutex sync.Mutex
+	// 0 keeps the SSRC of the packets
+	ssrc      uint32
+	clockRate uint32
+
+	// the stream the packets come from, any comparable value, the
+	// packets of other streams are dropped
+	source   any
+	switched bool
+
+	started         bool
+	sequenceOffset  uint16
+	timestampOffset uint32
+	lastSequence    uint16
+	lastTimestamp   uint32
+	lastTime        time.Time
+}
+
+func NewRTPRewriter(ssrc uint32, clockRate uint32) *RTPRewriter {
+	if clockRate == 0 {
+		clockRate = 90000
+	}
+
+	return &RTPRewriter{ssrc: ssrc, clockRate: clockRate}
+}
+
+func (rewriter *RTPRewriter) SetClockRate(clockRate uint32) {
+	rewriter.mutex.Lock()
+	defer rewriter.mutex.Unlock()
+
+	if clockRate != 0 {
+		rewriter.clockRate = clockRate
+	}
+}
+
+// Take the packets of source from now on.
+func (rewriter *RTPRewriter) SwitchTo(source any) {
+	rewriter.mutex.Lock()
+	defer rewriter.mutex.Unlock()
+
+	rewriter.source = source
+	rewriter.switched = true
+}
+
+// Stop taking the packets of source, if it is the stream switched to.
+func (rewriter *RTPRewriter) Release(source any) {
+	rewriter.mutex.Lock()
+	defer rewriter.mutex.Unlock()
+
+	if rewriter.source == source {
+		rewriter.source = nil
+	}
+}
+
+// Copy packet as it goes out into outbound, false when source is not the
+// stream switched to. The packet itself is not changed, it can be shared,
+// outbound shares its payload.
+func (rewriter *RTPRewriter) Rewrite(source any, packet *rtp.Packet, outbound *rtp.Packet) bool {
+	rewriter.mutex.Lock()
+	defer rewriter.mutex.Unlock()
+
+	if source != rewriter.source {
+		return false
+	}
+
+	if rewriter.switched {
+		// continue right after the last packet of the old stream
+		if rewriter.started {
+			elapsed := uint32(time.Since(rewriter.lastTime) * time.Duration(rewriter.clockRate) / time.Second)
+			rewriter.sequenceOffset = rewriter.lastSequence + 1 - packet.SequenceNumber
+			rewriter.timestampOffset = rewriter.lastTimestamp + elapsed - packet.Timestamp
+		}
+		rewriter.switched = false
+	}
+
+	*outbound = *packet
+	outbound.SequenceNumber += rewriter.sequenceOffset
+	outbound.Timestamp += rewriter.timestampOffset
+	if rewriter.ssrc != 0 {
+		outbound.SSRC = rewriter.ssrc
+	}
+
+	rewriter.started = true
+	rewriter.lastSequence = outbound.SequenceNumber
+	rewriter.lastTimestamp = outbound.Timestamp
+	rewriter.lastTime = time.Now()
+
+	return true
+}
+
+// The continuous streams of the local consumers, they outlive the guests
+// that feed them.
+type EgressStreams struct {
+	mutex   sync.Mutex
+	streams map[string]*RTPRewriter
+}
+
+func NewEgressStreams() *EgressStreams {
+	return &EgressStreams{streams: map[string]*RTPRewriter{}}
+}
+
+// The stream going to key, like the resolved address of a UDP sink.
+// Without a registry every caller gets a stream of its own.
+func (registry *EgressStreams) get(key string) *RTPRewriter {
+	if registry == nil {
+		return NewRTPRewriter(RandomSSRC(), 0)
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	stream, found := registry.streams[key]
+	if !found {
+		stream = NewRTPRewriter(RandomSSRC(), 0)
+		registry.streams[key] = stream
+	}
+
+	return stream
+}
+
+func RandomSSRC() uint32 {
+	var ssrc [4]byte
+	if _, err := rand.Read(ssrc[:]); err != nil {
+		panic(fmt.Sprintf("logic: rand.Read - %s", err))
+	}
+
+	// never 0, that keeps the SSRC of the packets
+	return binary.BigEndian.Uint32(ssrc[:]) | 1
+}
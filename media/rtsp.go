@@ -0,0 +1,579 @@
+package media
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// path of the stream that follows the guest on air
+const RTSPGuestPath = "/guest"
+
+// A small RTSP server for players like VLC, OBS or an NVR. It only offers
+// RTP interleaved in the RTSP connection, which gets through firewalls and
+// does not lose packets on a busy loopback.
+//
+// /guest plays the guest on air, /guest/<index> plays one peer whether it
+// is on air or not. A path goes on with the same SSRC, sequence numbers
+// and payload types from guest to guest.
+type RTSPServer struct {
+	listener net.Listener
+
+	mutex   sync.Mutex
+	streams map[string]*rtspStream
+	// every open connection, with or without a stream
+	connections map[*rtspConnection]bool
+}
+
+// The tracks of one path, indexed by Type: audio is trackID=0 and
+// video is trackID=1.
+type rtspStream struct {
+	tracks  [2]*rtspTrack
+	players map[*rtspConnection]bool
+	// what the players get, whichever sink owns the track
+	rewriters    [2]*RTPRewriter
+	payloadTypes [2]webrtc.PayloadType
+}
+
+type rtspTrack struct {
+	owner           *rtspSink
+	codec           webrtc.RTPCodecParameters
+	requestKeyframe func()
+}
+
+type rtspConnection struct {
+	server     *RTSPServer
+	connection net.Conn
+	// responses and interleaved frames, written in order by one goroutine
+	writes    chan []byte
+	closeOnce sync.Once
+
+	// set up by SETUP, guarded by the server mutex
+	session  string
+	path     string
+	channels [2]int
+}
+
+func NewRTSPServer(address string) (*RTSPServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &RTSPServer{
+		listener:    listener,
+		streams:     map[string]*rtspStream{},
+		connections: map[*rtspConnection]bool{},
+	}
+
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *RTSPServer) serve() {
+	for {
+		connection, err := server.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"rtsp: accept - %s\n",
+				err)
+			return
+		}
+
+		rtspConnection := &rtspConnection{
+			server:     server,
+			connection: connection,
+			writes:     make(chan []byte, 256),
+			channels:   [2]int{-1, -1},
+		}
+
+		server.mutex.Lock()
+		server.connections[rtspConnection] = true
+		server.mutex.Unlock()
+
+		go rtspConnection.writeLoop()
+		go rtspConnection.readLoop()
+	}
+}
+
+// Stop listening and drop the players.
+func (server *RTSPServer) Close() error {
+	err := server.listener.Close()
+
+	server.mutex.Lock()
+	for connection := range server.connections {
+		connection.close()
+	}
+	server.mutex.Unlock()
+
+	return err
+}
+
+func (server *RTSPServer) stream(path string) *rtspStream {
+	stream, found := server.streams[path]
+	if !found {
+		stream = &rtspStream{
+			players: map[*rtspConnection]bool{},
+		}
+		server.streams[path] = stream
+	}
+
+	return stream
+}
+
+func (server *RTSPServer) setTrack(sink *rtspSink, codec webrtc.RTPCodecParameters) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	stream := server.stream(sink.path)
+
+	// the players keep the payload type of the first track of the path
+	rewriter := stream.rewriters[sink.mediaType]
+	if rewriter == nil {
+		rewriter = NewRTPRewriter(RandomSSRC(), codec.ClockRate)
+		stream.rewriters[sink.mediaType] = rewriter
+		stream.payloadTypes[sink.mediaType] = codec.PayloadType
+	} else {
+		rewriter.SetClockRate(codec.ClockRate)
+	}
+	rewriter.SwitchTo(sink)
+	codec.PayloadType = stream.payloadTypes[sink.mediaType]
+
+	stream.tracks[sink.mediaType] = &rtspTrack{
+		owner:           sink,
+		codec:           codec,
+		requestKeyframe: sink.requestKeyframe,
+	}
+}
+
+func (server *RTSPServer) removeTrack(sink *rtspSink) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	stream, found := server.streams[sink.path]
+	if !found {
+		return
+	}
+
+	track := stream.tracks[sink.mediaType]
+	if track == nil || track.owner != sink {
+		return
+	}
+	stream.tracks[sink.mediaType] = nil
+	stream.rewriters[sink.mediaType].Release(sink)
+
+	// the per peer paths go away with the peer, the guest path keeps its
+	// players for the next guest
+	if sink.path != RTSPGuestPath &&
+		stream.tracks[Audio] == nil &&
+		stream.tracks[Video] == nil {
+
+		for player := range stream.players {
+			player.close()
+		}
+		delete(server.streams, sink.path)
+	}
+}
+
+func (server *RTSPServer) writeRTP(sink *rtspSink, packet *rtp.Packet) error {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	stream, found := server.streams[sink.path]
+	if !found {
+		return nil
+	}
+
+	track := stream.tracks[sink.mediaType]
+	if track == nil || track.owner != sink {
+		return nil
+	}
+
+	var outbound rtp.Packet
+	if !stream.rewriters[sink.mediaType].Rewrite(sink, packet, &outbound) {
+		return nil
+	}
+	outbound.PayloadType = uint8(stream.payloadTypes[sink.mediaType])
+
+	payload, err := outbound.Marshal()
+	if err != nil {
+		return err
+	}
+
+	for player := range stream.players {
+		channel := player.channels[sink.mediaType]
+		if channel < 0 {
+			continue
+		}
+
+		frame := make([]byte, 4+len(payload))
+		frame[0] = '$'
+		frame[1] = byte(channel)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+		copy(frame[4:], payload)
+
+		// a slow player loses packets instead of holding up the guest
+		select {
+		case player.writes <- frame:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (server *RTSPServer) describe(path string, baseURL string) (string, bool) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	stream, found := server.streams[path]
+	if !found {
+		return "", false
+	}
+
+	var description strings.Builder
+	description.WriteString("v=0\r\n")
+	description.WriteString("o=- 0 0 IN IP4 127.0.0.1\r\n")
+	description.WriteString("s=meetupstation-pion\r\n")
+	description.WriteString("c=IN IP4 0.0.0.0\r\n")
+	description.WriteString("t=0 0\r\n")
+	description.WriteString("a=control:" + baseURL + "\r\n")
+
+	hasTracks := false
+	for trackID, track := range stream.tracks {
+		if track == nil {
+			continue
+		}
+		hasTracks = true
+
+		kind, encoding, _ := strings.Cut(track.codec.MimeType, "/")
+		rtpmap := fmt.Sprintf("%s/%d", encoding, track.codec.ClockRate)
+		if track.codec.Channels > 0 {
+			rtpmap += fmt.Sprintf("/%d", track.codec.Channels)
+		}
+
+		fmt.Fprintf(&description, "m=%s 0 RTP/AVP %d\r\n", kind, track.codec.PayloadType)
+		fmt.Fprintf(&description, "a=rtpmap:%d %s\r\n", track.codec.PayloadType, rtpmap)
+		if track.codec.SDPFmtpLine != "" {
+			fmt.Fprintf(&description, "a=fmtp:%d %s\r\n", track.codec.PayloadType, track.codec.SDPFmtpLine)
+		}
+		fmt.Fprintf(&description, "a=control:trackID=%d\r\n", trackID)
+	}
+
+	return description.String(), hasTracks
+}
+
+// Ask the guest for keyframes, so that a new player gets a picture now
+// instead of at the next periodic keyframe.
+func (server *RTSPServer) requestKeyframes(path string) {
+	var requests []func()
+
+	server.mutex.Lock()
+	if stream, found := server.streams[path]; found {
+		for _, track := range stream.tracks {
+			if track != nil && track.requestKeyframe != nil {
+				requests = append(requests, track.requestKeyframe)
+			}
+		}
+	}
+	server.mutex.Unlock()
+
+	for _, request := range requests {
+		request()
+	}
+}
+
+func (connection *rtspConnection) close() {
+	connection.closeOnce.Do(func() {
+		connection.connection.Close()
+	})
+}
+
+func (connection *rtspConnection) writeLoop() {
+	for write := range connection.writes {
+		connection.connection.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := connection.connection.Write(write); err != nil {
+			connection.close()
+			// keep draining until the read loop is done
+		}
+	}
+}
+
+func (connection *rtspConnection) readLoop() {
+	defer func() {
+		connection.server.mutex.Lock()
+		if stream, found := connection.server.streams[connection.path]; found {
+			delete(stream.players, connection)
+		}
+		delete(connection.server.connections, connection)
+		connection.server.mutex.Unlock()
+
+		connection.close()
+		close(connection.writes)
+	}()
+
+	reader := bufio.NewReader(connection.connection)
+	headerReader := textproto.NewReader(reader)
+
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return
+		}
+
+		// RTCP from the player, interleaved the same way
+		if first[0] == '$' {
+			header := make([]byte, 4)
+			if _, err = io.ReadFull(reader, header); err != nil {
+				return
+			}
+			if _, err = reader.Discard(int(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return
+			}
+			continue
+		}
+
+		requestLine, err := headerReader.ReadLine()
+		if err != nil {
+			return
+		}
+		if requestLine == "" {
+			continue
+		}
+
+		header, err := headerReader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		if contentLength, _ := strconv.Atoi(header.Get("Content-Length")); contentLength > 0 {
+			if _, err = reader.Discard(contentLength); err != nil {
+				return
+			}
+		}
+
+		method, requestURL, _ := strings.Cut(requestLine, " ")
+		requestURL, _, _ = strings.Cut(requestURL, " ")
+
+		if !connection.handle(method, requestURL, header) {
+			return
+		}
+	}
+}
+
+func (connection *rtspConnection) respond(status string,
+	requestHeader textproto.MIMEHeader,
+	header []string,
+	body string) {
+
+	var response strings.Builder
+	response.WriteString("RTSP/1.0 " + status + "\r\n")
+	response.WriteString("CSeq: " + requestHeader.Get("CSeq") + "\r\n")
+	response.WriteString("Server: meetupstation-pion\r\n")
+	for _, line := range header {
+		response.WriteString(line + "\r\n")
+	}
+	if body != "" {
+		response.WriteString("Content-Length: " + strconv.Itoa(len(body)) + "\r\n")
+	}
+	response.WriteString("\r\n")
+	response.WriteString(body)
+
+	connection.writes <- []byte(response.String())
+}
+
+// Handle one request, false closes the connection.
+func (connection *rtspConnection) handle(method string,
+	requestURL string,
+	header textproto.MIMEHeader) bool {
+
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil && requestURL != "*" {
+		connection.respond("400 Bad Request", header, nil, "")
+		return true
+	}
+
+	path := ""
+	trackID := -1
+	if parsedURL != nil {
+		path = strings.TrimSuffix(parsedURL.Path, "/")
+		if base, track, found := strings.Cut(path, "/trackID="); found {
+			path = base
+			trackID, err = strconv.Atoi(track)
+			if err != nil || trackID < 0 || trackID > 1 {
+				connection.respond("404 Not Found", header, nil, "")
+				return true
+			}
+		}
+	}
+
+	switch method {
+	case "OPTIONS":
+		connection.respond("200 OK", header,
+			[]string{"Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER, SET_PARAMETER"},
+			"")
+	case "DESCRIBE":
+		baseURL := strings.TrimSuffix(requestURL, "/") + "/"
+		description, found := connection.server.describe(path, baseURL)
+		if !found {
+			connection.respond("404 Not Found", header, nil, "")
+			return true
+		}
+
+		connection.respond("200 OK", header,
+			[]string{
+				"Content-Type: application/sdp",
+				"Content-Base: " + baseURL,
+			},
+			description)
+	case "SETUP":
+		return connection.setup(path, trackID, header)
+	case "PLAY":
+		connection.server.mutex.Lock()
+		stream, found := connection.server.streams[connection.path]
+		if found && connection.session != "" {
+			stream.players[connection] = true
+		}
+		connection.server.mutex.Unlock()
+
+		if !found || connection.session == "" {
+			connection.respond("454 Session Not Found", header, nil, "")
+			return true
+		}
+
+		connection.respond("200 OK", header,
+			[]string{
+				"Session: " + connection.session,
+				"Range: npt=0.000-",
+			},
+			"")
+		connection.server.requestKeyframes(connection.path)
+	case "TEARDOWN":
+		connection.respond("200 OK", header, nil, "")
+		return false
+	case "GET_PARAMETER", "SET_PARAMETER":
+		// keepalive
+		connection.respond("200 OK", header, nil, "")
+	default:
+		connection.respond("501 Not Implemented", header, nil, "")
+	}
+
+	return true
+}
+
+func (connection *rtspConnection) setup(path string,
+	trackID int,
+	header textproto.MIMEHeader) bool {
+
+	transport := header.Get("Transport")
+	if !strings.Contains(transport, "RTP/AVP/TCP") {
+		connection.respond("461 Unsupported Transport", header, nil, "")
+		return true
+	}
+
+	if trackID < 0 {
+		connection.respond("459 Aggregate Operation Not Allowed", header, nil, "")
+		return true
+	}
+
+	// channel pairs follow the track ids unless the player asks
+	channel := 2 * trackID
+	for _, parameter := range strings.Split(transport, ";") {
+		if value, found := strings.CutPrefix(parameter, "interleaved="); found {
+			first, _, _ := strings.Cut(value, "-")
+			if parsed, err := strconv.Atoi(first); err == nil && parsed >= 0 && parsed < 255 {
+				channel = parsed
+			}
+		}
+	}
+
+	connection.server.mutex.Lock()
+	stream, found := connection.server.streams[path]
+	ok := found &&
+		stream.tracks[trackID] != nil &&
+		(connection.path == "" || connection.path == path)
+	if ok {
+		connection.path = path
+		connection.channels[trackID] = channel
+		if connection.session == "" {
+			sessionID := make([]byte, 8)
+			rand.Read(sessionID)
+			connection.session = hex.EncodeToString(sessionID)
+		}
+	}
+	connection.server.mutex.Unlock()
+
+	if !ok {
+		connection.respond("404 Not Found", header, nil, "")
+		return true
+	}
+
+	connection.respond("200 OK", header,
+		[]string{
+			fmt.Sprintf("Transport: RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1),
+			"Session: " + connection.session + ";timeout=60",
+		},
+		"")
+
+	return true
+}
+
+// Publishes the media of one peer on a path, the last one bound owns it.
+type rtspSink struct {
+	server          *RTSPServer
+	path            string
+	mediaType       Type
+	requestKeyframe func()
+}
+
+func newRTSPSink(server *RTSPServer, mediaType Type, path string) *rtspSink {
+	return &rtspSink{
+		server:    server,
+		path:      path,
+		mediaType: mediaType,
+	}
+}
+
+// The sink of /guest/<index>, which plays the peer whether it is on air
+// or not.
+func NewRTSPPeerSink(server *RTSPServer, mediaType Type, peerIndex int) Sink {
+	return newRTSPSink(server, mediaType, fmt.Sprintf("%s/%d", RTSPGuestPath, peerIndex))
+}
+
+// Lets the sink ask the remote for a keyframe.
+func (sink *rtspSink) OnKeyframeNeeded(requestKeyframe func()) {
+	sink.requestKeyframe = requestKeyframe
+}
+
+func (sink *rtspSink) Bind(codec webrtc.RTPCodecParameters) error {
+	sink.server.setTrack(sink, codec)
+	return nil
+}
+
+func (sink *rtspSink) WriteRTP(packet *rtp.Packet) error {
+	return sink.server.writeRTP(sink, packet)
+}
+
+func (sink *rtspSink) Close() error {
+	sink.server.removeTrack(sink)
+	return nil
+}
@@ -0,0 +1,294 @@
+package media
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	testPatternFrameRate   = 15
+	testPatternWidthInMbs  = 11 // 176 pixels
+	testPatternHeightInMbs = 9  // 144 pixels
+	// an IDR every two seconds, so that late joiners get a picture
+	testPatternIDRInterval = 2 * testPatternFrameRate
+)
+
+// Y, Cb, Cr of the classic colour bars, white to black
+var testPatternBars = [8][3]byte{
+	{235, 128, 128},
+	{210, 16, 146},
+	{170, 166, 16},
+	{145, 54, 34},
+	{106, 202, 222},
+	{81, 90, 240},
+	{41, 240, 110},
+	{16, 128, 128},
+}
+
+// Generates an H264 constrained baseline stream of colour bars with a grey
+// column sweeping over them. There is no encoder around, so every coded
+// macroblock is I_PCM and the P frames skip the macroblocks that did not
+// change.
+type testPatternFrames struct {
+	frameRate  int
+	frameIndex int
+	idrIndex   int
+}
+
+func newTestPatternFrames(frameRate int) (*testPatternFrames, error) {
+	return &testPatternFrames{frameRate: frameRate}, nil
+}
+
+func (frames *testPatternFrames) codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}
+}
+
+func (frames *testPatternFrames) payloader() rtp.Payloader {
+	return &codecs.H264Payloader{}
+}
+
+func (frames *testPatternFrames) nextFrame() ([]byte, time.Duration, error) {
+	frameNumber := frames.frameIndex % testPatternIDRInterval
+	sweep := frames.frameIndex % testPatternWidthInMbs
+
+	var frame []byte
+	if frameNumber == 0 {
+		frame = append(frame, annexBNal(0x67, testPatternSPS())...)
+		frame = append(frame, annexBNal(0x68, testPatternPPS())...)
+		frame = append(frame, annexBNal(0x65, frames.idrSlice(sweep))...)
+		frames.idrIndex++
+	} else {
+		previous := (frames.frameIndex - 1) % testPatternWidthInMbs
+		frame = annexBNal(0x61, testPatternPSlice(frameNumber, sweep, previous))
+	}
+
+	frameTime := time.Duration(frames.frameIndex) * time.Second / time.Duration(frames.frameRate)
+	frames.frameIndex++
+
+	return frame, frameTime, nil
+}
+
+func (frames *testPatternFrames) rewind() error {
+	return nil
+}
+
+func (frames *testPatternFrames) close() error {
+	return nil
+}
+
+func testPatternSPS() []byte {
+	writer := &bitWriter{}
+	writer.writeBits(66, 8)   // profile_idc: baseline
+	writer.writeBits(0xe0, 8) // constraint_set0..2: constrained baseline
+	writer.writeBits(31, 8)   // level_idc 3.1
+	writer.writeUE(0)         // seq_parameter_set_id
+	writer.writeUE(0)         // log2_max_frame_num_minus4
+	writer.writeUE(2)         // pic_order_cnt_type: output in decoding order
+	writer.writeUE(1)         // max_num_ref_frames
+	writer.writeBits(0, 1)    // gaps_in_frame_num_value_allowed_flag
+	writer.writeUE(testPatternWidthInMbs - 1)
+	writer.writeUE(testPatternHeightInMbs - 1)
+	writer.writeBits(1, 1) // frame_mbs_only_flag
+	writer.writeBits(1, 1) // direct_8x8_inference_flag
+	writer.writeBits(0, 1) // frame_cropping_flag
+	writer.writeBits(0, 1) // vui_parameters_present_flag
+	writer.writeTrailingBits()
+
+	return writer.data
+}
+
+func testPatternPPS() []byte {
+	writer := &bitWriter{}
+	writer.writeUE(0)      // pic_parameter_set_id
+	writer.writeUE(0)      // seq_parameter_set_id
+	writer.writeBits(0, 1) // entropy_coding_mode_flag: CAVLC
+	writer.writeBits(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	writer.writeUE(0)      // num_slice_groups_minus1
+	writer.writeUE(0)      // num_ref_idx_l0_default_active_minus1
+	writer.writeUE(0)      // num_ref_idx_l1_default_active_minus1
+	writer.writeBits(0, 1) // weighted_pred_flag
+	writer.writeBits(0, 2) // weighted_bipred_idc
+	writer.writeSE(0)      // pic_init_qp_minus26
+	writer.writeSE(0)      // pic_init_qs_minus26
+	writer.writeSE(0)      // chroma_qp_index_offset
+	writer.writeBits(0, 1) // deblocking_filter_control_present_flag
+	writer.writeBits(0, 1) // constrained_intra_pred_flag
+	writer.writeBits(0, 1) // redundant_pic_cnt_present_flag
+	writer.writeTrailingBits()
+
+	return writer.data
+}
+
+func (frames *testPatternFrames) idrSlice(sweep int) []byte {
+	writer := &bitWriter{}
+	writer.writeUE(0)      // first_mb_in_slice
+	writer.writeUE(7)      // slice_type: I, all slices
+	writer.writeUE(0)      // pic_parameter_set_id
+	writer.writeBits(0, 4) // frame_num
+	writer.writeUE(uint32(frames.idrIndex % 2))
+	writer.writeBits(0, 1) // no_output_of_prior_pics_flag
+	writer.writeBits(0, 1) // long_term_reference_flag
+	writer.writeSE(0)      // slice_qp_delta
+
+	for mb := 0; mb < testPatternWidthInMbs*testPatternHeightInMbs; mb++ {
+		writer.writeUE(25) // mb_type: I_PCM in an I slice
+		writePCMMacroblock(writer, mb%testPatternWidthInMbs, sweep)
+	}
+	writer.writeTrailingBits()
+
+	return writer.data
+}
+
+func testPatternPSlice(frameNumber int, sweep int, previous int) []byte {
+	writer := &bitWriter{}
+	writer.writeUE(0)                           // first_mb_in_slice
+	writer.writeUE(5)                           // slice_type: P, all slices
+	writer.writeUE(0)                           // pic_parameter_set_id
+	writer.writeBits(uint64(frameNumber%16), 4) // frame_num
+	writer.writeBits(0, 1)                      // num_ref_idx_active_override_flag
+	writer.writeBits(0, 1)                      // ref_pic_list_modification_flag_l0
+	writer.writeBits(0, 1)                      // adaptive_ref_pic_marking_mode_flag
+	writer.writeSE(0)                           // slice_qp_delta
+
+	skipRun := 0
+	for mb := 0; mb < testPatternWidthInMbs*testPatternHeightInMbs; mb++ {
+		column := mb % testPatternWidthInMbs
+		if column != sweep && column != previous {
+			skipRun++
+			continue
+		}
+
+		writer.writeUE(uint32(skipRun)) // mb_skip_run
+		skipRun = 0
+		writer.writeUE(30) // mb_type: I_PCM in a P slice
+		writePCMMacroblock(writer, column, sweep)
+	}
+	if skipRun > 0 {
+		writer.writeUE(uint32(skipRun))
+	}
+	writer.writeTrailingBits()
+
+	return writer.data
+}
+
+func writePCMMacroblock(writer *bitWriter, column int, sweep int) {
+	colour := testPatternBars[column*len(testPatternBars)/testPatternWidthInMbs]
+	if column == sweep {
+		colour = [3]byte{128, 128, 128}
+	}
+
+	writer.alignZero() // pcm_alignment_zero_bit
+	for i := 0; i < 256; i++ {
+		writer.data = append(writer.data, colour[0])
+	}
+	for i := 0; i < 64; i++ {
+		writer.data = append(writer.data, colour[1])
+	}
+	for i := 0; i < 64; i++ {
+		writer.data = append(writer.data, colour[2])
+	}
+}
+
+// Opus has no encoder around either, the audio test source sends the
+// 20ms silence frame.
+type silenceFrames struct {
+	frameIndex int
+}
+
+func newSilenceFrames() (*silenceFrames, error) {
+	return &silenceFrames{}, nil
+}
+
+func (frames *silenceFrames) codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+}
+
+func (frames *silenceFrames) payloader() rtp.Payloader {
+	return &codecs.OpusPayloader{}
+}
+
+func (frames *silenceFrames) nextFrame() ([]byte, time.Duration, error) {
+	frameTime := time.Duration(frames.frameIndex) * 20 * time.Millisecond
+	frames.frameIndex++
+
+	return []byte{0xf8, 0xff, 0xfe}, frameTime, nil
+}
+
+func (frames *silenceFrames) rewind() error {
+	return nil
+}
+
+func (frames *silenceFrames) close() error {
+	return nil
+}
+
+// Writes the RBSP of a NAL unit most significant bit first.
+type bitWriter struct {
+	data []byte
+	// bits used in the last byte, 0 when it is full
+	used uint
+}
+
+func (writer *bitWriter) writeBits(value uint64, count uint) {
+	for count > 0 {
+		count--
+		if writer.used == 0 {
+			writer.data = append(writer.data, 0)
+		}
+
+		writer.data[len(writer.data)-1] |= byte((value>>count)&1) << (7 - writer.used)
+		writer.used = (writer.used + 1) % 8
+	}
+}
+
+// unsigned Exp-Golomb
+func (writer *bitWriter) writeUE(value uint32) {
+	length := uint(bits.Len64(uint64(value) + 1))
+	writer.writeBits(0, length-1)
+	writer.writeBits(uint64(value)+1, length)
+}
+
+// signed Exp-Golomb
+func (writer *bitWriter) writeSE(value int32) {
+	if value > 0 {
+		writer.writeUE(uint32(2*value - 1))
+	} else {
+		writer.writeUE(uint32(-2 * value))
+	}
+}
+
+func (writer *bitWriter) alignZero() {
+	writer.used = 0
+}
+
+func (writer *bitWriter) writeTrailingBits() {
+	writer.writeBits(1, 1)
+	writer.alignZero()
+}
+
+// Prefix a NAL unit with a start code and escape its RBSP, so that no
+// start code shows up inside it.
+func annexBNal(header byte, rbsp []byte) []byte {
+	nal := []byte{0, 0, 0, 1, header}
+
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			nal = append(nal, 3)
+			zeros = 0
+		}
+
+		nal = append(nal, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return nal
+}
@@ -0,0 +1,127 @@
+package signalling
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+	"golang.org/x/crypto/hkdf"
+)
+
+// prefix of sealed descriptions, so that a plain one is never mistaken
+// for a sealed one and the other way around
+const sealedDescriptionPrefix = "e2e1."
+
+// Seals session descriptions with a key that only the host and the guest
+// know, so that the signalling server can neither read the candidates and
+// fingerprints nor change them unnoticed.
+type descriptionSealer struct {
+	roomSecret []byte
+}
+
+func newDescriptionSealer(roomSecret string) *descriptionSealer {
+	return &descriptionSealer{
+		roomSecret: []byte(roomSecret),
+	}
+}
+
+// Derive an AES-256-GCM key for one room from the shared room secret.
+func (sealer *descriptionSealer) aead(hostId string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(
+		hkdf.New(sha256.New,
+			sealer.roomSecret,
+			[]byte("meetupstation-pion sdp v1"),
+			[]byte(hostId)),
+		key)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// The room and the description type are bound to the ciphertext, so that
+// the server can not replay an offer as an answer or move it between rooms.
+func additionalData(hostId string, sdpType webrtc.SDPType) []byte {
+	return []byte(hostId + "\n" + sdpType.String())
+}
+
+// JSON encode, seal and base64 a SessionDescription.
+func (sealer *descriptionSealer) seal(hostId string, obj *webrtc.SessionDescription) (string, error) {
+	aead, err := sealer.aead(hostId)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData(hostId, obj.Type))
+
+	return sealedDescriptionPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decode a base64, open and unmarshal JSON into a SessionDescription of the
+// expected type. Plain descriptions are refused.
+func (sealer *descriptionSealer) open(hostId string,
+	sdpType webrtc.SDPType,
+	in string,
+	obj *webrtc.SessionDescription) error {
+
+	if !strings.HasPrefix(in, sealedDescriptionPrefix) {
+		return errors.New("refusing a description that is not sealed with the room secret")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(
+		strings.TrimPrefix(in, sealedDescriptionPrefix))
+	if err != nil {
+		return err
+	}
+
+	aead, err := sealer.aead(hostId)
+	if err != nil {
+		return err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return errors.New("sealed description is too short")
+	}
+
+	plaintext, err := aead.Open(nil,
+		sealed[:aead.NonceSize()],
+		sealed[aead.NonceSize():],
+		additionalData(hostId, sdpType))
+	if err != nil {
+		return errors.New("sealed description does not open with the room secret")
+	}
+
+	if err = json.Unmarshal(plaintext, obj); err != nil {
+		return err
+	}
+
+	if obj.Type != sdpType {
+		return errors.New("sealed description has an unexpected type")
+	}
+
+	return nil
+}
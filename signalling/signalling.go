@@ -0,0 +1,617 @@
+// Package signalling is the client of the meetupstation signalling
+// server, which passes the offer of the host and the answer of a guest
+// through a room with a secret host id.
+package signalling
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type Options struct {
+	// sent as "Authorization: Bearer <token>" when not empty
+	Token string
+	// signs every request with HMAC-SHA256 when not empty
+	HMACKey string
+	// PEM bundle of extra certificate authorities to trust
+	CAFile string
+	// PEM client certificate and key for mutual TLS
+	CertFile string
+	KeyFile  string
+	// allow the host id and credentials to go over plain http://
+	AllowInsecure bool
+	// seal the descriptions end to end when not empty
+	RoomSecret string
+	// called with every failed exchange with the server before it is
+	// tried again, must not block
+	OnError func(peerIndex int, err error)
+}
+
+// POST /api/host
+type hostSetupRequest struct {
+	Id          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// GET /api/host?id=
+type hostResponse struct {
+	Description string `json:"description"`
+}
+
+// POST /api/guest
+type guestSetupRequest struct {
+	HostId           string `json:"hostId"`
+	GuestDescription string `json:"guestDescription"`
+}
+
+// GET /api/guest?hostId=
+//
+// The description stays empty until the guest has signalled.
+type guestResponse struct {
+	GuestDescription string `json:"guestDescription"`
+}
+
+// The POST endpoints answer with a status only, their bodies are not read.
+
+// Decode a signalling response into its struct. Fields of the wrong type
+// and anything after the JSON object are errors instead of being dropped.
+func decodeSignalResponse(body io.Reader, response interface{}) error {
+	decoder := json.NewDecoder(body)
+
+	if err := decoder.Decode(response); err != nil {
+		return err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the response object")
+	}
+
+	return nil
+}
+
+type Client struct {
+	server     *url.URL
+	httpClient *http.Client
+	token      string
+	hmacKey    []byte
+	sealer     *descriptionSealer
+	onError    func(peerIndex int, err error)
+}
+
+func NewClient(signalServer string, options Options) (*Client, error) {
+	server, err := url.Parse(signalServer)
+	if err != nil {
+		return nil, err
+	}
+
+	switch server.Scheme {
+	case "https":
+	case "http":
+		// the host id is a secret on its own, so plain http is
+		// refused even without a token or an hmac key
+		if !options.AllowInsecure {
+			return nil, fmt.Errorf(
+				"refusing to send credentials over %s, use https or allow insecure signalling",
+				signalServer)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signalling server scheme %q", server.Scheme)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if options.CAFile != "" {
+		caBundle, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs, err = x509.SystemCertPool()
+		if err != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, errors.New("client certificate needs both the certificate and the key file")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	var sealer *descriptionSealer
+	if options.RoomSecret != "" {
+		sealer = newDescriptionSealer(options.RoomSecret)
+	}
+
+	return &Client{
+		server: server,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+		token:   options.Token,
+		hmacKey: []byte(options.HMACKey),
+		sealer:  sealer,
+		onError: options.OnError,
+	}, nil
+}
+
+// Log a failed exchange with the server and pass it on.
+func (signalClient *Client) fail(peerIndex int, doing string, err error) {
+	fmt.Fprintf(os.Stderr,
+		"conn %d: %s: %s\n",
+		peerIndex,
+		doing,
+		err)
+
+	if signalClient.onError != nil {
+		signalClient.onError(peerIndex, fmt.Errorf("%s: %w", doing, err))
+	}
+}
+
+// Build a request to the signalling server with the configured
+// authentication applied.
+//
+// The HMAC signature covers the method, the request uri, a unix
+// timestamp and the sha256 of the body, one per line.
+func (signalClient *Client) newRequest(ctx context.Context,
+	method string,
+	path string,
+	params url.Values,
+	body []byte) (*http.Request, error) {
+
+	requestURL := *signalClient.server
+	requestURL.Path = strings.TrimSuffix(signalClient.server.Path, "/") + path
+	requestURL.RawQuery = params.Encode()
+
+	request, err := http.NewRequestWithContext(ctx,
+		method,
+		requestURL.String(),
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		request.Header.Add("Content-type", "application/json; charset=UTF-8")
+	}
+
+	if signalClient.token != "" {
+		request.Header.Set("Authorization", "Bearer "+signalClient.token)
+	}
+
+	if len(signalClient.hmacKey) != 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		bodyHash := sha256.Sum256(body)
+
+		mac := hmac.New(sha256.New, signalClient.hmacKey)
+		fmt.Fprintf(mac, "%s\n%s\n%s\n%s",
+			method,
+			requestURL.RequestURI(),
+			timestamp,
+			hex.EncodeToString(bodyHash[:]))
+
+		request.Header.Set("X-Meetupstation-Timestamp", timestamp)
+		request.Header.Set("X-Meetupstation-Signature", hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	return request, nil
+}
+
+// Wait before trying an exchange again, an error when ctx is done first.
+func retryDelay(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(1 * time.Second):
+		return nil
+	}
+}
+
+// Post the description of the host until the server takes it, an error
+// only when ctx is done.
+func (signalClient *Client) HostSetup(ctx context.Context,
+	hostId string,
+	peerLocalSessionDescription webrtc.SessionDescription,
+	peerIndex int) error {
+
+	description, err := signalClient.encode(hostId, &peerLocalSessionDescription)
+	if err != nil {
+		panic(fmt.Sprintf("logic: encode local description - %s", err))
+	}
+
+	body, err := json.Marshal(hostSetupRequest{
+		Id:          hostId,
+		Description: description,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("logic: marshal host setup request - %s", err))
+	}
+
+	for {
+		request, err := signalClient.newRequest(ctx,
+			http.MethodPost,
+			"/api/host",
+			nil,
+			body)
+		if err != nil {
+			signalClient.fail(peerIndex,
+				"while setting up hostId with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		hostSignal, err := signalClient.httpClient.Do(request)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			signalClient.fail(peerIndex,
+				"while setting up hostId with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		allOK := hostSignal.StatusCode == http.StatusOK
+		hostSignal.Body.Close()
+
+		if allOK {
+			return nil
+		} else {
+			signalClient.fail(peerIndex,
+				"while setting up hostId with signalling server",
+				errors.New("response status"))
+			if err := retryDelay(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+	}
+}
+
+// Poll the server for the offer of the host, an error only when ctx is
+// done.
+func (signalClient *Client) WaitForHost(ctx context.Context,
+	hostId string,
+	peerIndex int) (webrtc.SessionDescription, error) {
+
+	for {
+		params := url.Values{}
+		params.Add("id", hostId)
+
+		request, err := signalClient.newRequest(ctx,
+			http.MethodGet,
+			"/api/host",
+			params,
+			nil)
+		if err != nil {
+			signalClient.fail(peerIndex,
+				"while getting host information with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			continue
+		}
+
+		hostSignal, err := signalClient.httpClient.Do(request)
+		if err != nil {
+			if ctx.Err() != nil {
+				return webrtc.SessionDescription{}, ctx.Err()
+			}
+			signalClient.fail(peerIndex,
+				"while getting host information with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			continue
+		}
+		var hostDescriptionObject hostResponse
+		allOK := hostSignal.StatusCode == http.StatusOK
+		if allOK {
+			err = decodeSignalResponse(hostSignal.Body, &hostDescriptionObject)
+		}
+		hostSignal.Body.Close()
+
+		if allOK {
+			if err != nil {
+				signalClient.fail(peerIndex,
+					"while decoding the host signal",
+					err)
+				if err := retryDelay(ctx); err != nil {
+					return webrtc.SessionDescription{}, err
+				}
+				continue
+			}
+
+			hostOffer := webrtc.SessionDescription{}
+			err = signalClient.decode(hostId,
+				webrtc.SDPTypeOffer,
+				hostDescriptionObject.Description,
+				&hostOffer)
+			if err != nil {
+				signalClient.fail(peerIndex,
+					"while decoding the host description",
+					err)
+				if err := retryDelay(ctx); err != nil {
+					return webrtc.SessionDescription{}, err
+				}
+				continue
+			}
+
+			return hostOffer, nil
+		} else {
+			signalClient.fail(peerIndex,
+				"while setting up hostId with signalling server",
+				errors.New("response status"))
+			if err := retryDelay(ctx); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			continue
+		}
+	}
+}
+
+// Post the description of the guest until the server takes it, an error
+// only when ctx is done.
+func (signalClient *Client) GuestSetup(ctx context.Context,
+	hostId string,
+	peerLocalSessionDescription webrtc.SessionDescription,
+	peerIndex int) error {
+
+	description, err := signalClient.encode(hostId, &peerLocalSessionDescription)
+	if err != nil {
+		panic(fmt.Sprintf("logic: encode local description - %s", err))
+	}
+
+	body, err := json.Marshal(guestSetupRequest{
+		HostId:           hostId,
+		GuestDescription: description,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("logic: marshal guest setup request - %s", err))
+	}
+
+	for {
+		request, err := signalClient.newRequest(ctx,
+			http.MethodPost,
+			"/api/guest",
+			nil,
+			body)
+		if err != nil {
+			signalClient.fail(peerIndex,
+				"while setting up guestDescription with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		guestSignal, err := signalClient.httpClient.Do(request)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			signalClient.fail(peerIndex,
+				"while setting up guestDescription with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		allOK := guestSignal.StatusCode == http.StatusOK
+		guestSignal.Body.Close()
+
+		if allOK {
+			return nil
+		} else {
+			signalClient.fail(peerIndex,
+				"while setting up guestDescription with signalling server",
+				errors.New("response status"))
+			if err := retryDelay(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+	}
+}
+
+// Poll the server for the answer of a guest, setting up the host first
+// when the server does not know it. An error only when ctx is done.
+func (signalClient *Client) WaitForGuest(ctx context.Context,
+	hostId string,
+	peerIndex int,
+	peerLocalSessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+
+	for {
+		params := url.Values{}
+		params.Add("hostId", hostId)
+
+		request, err := signalClient.newRequest(ctx,
+			http.MethodGet,
+			"/api/guest",
+			params,
+			nil)
+		if err != nil {
+			signalClient.fail(peerIndex,
+				"while getting guest information with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			continue
+		}
+
+		guestSignal, err := signalClient.httpClient.Do(request)
+		if err != nil {
+			if ctx.Err() != nil {
+				return webrtc.SessionDescription{}, ctx.Err()
+			}
+			signalClient.fail(peerIndex,
+				"while getting guest information with signalling server",
+				err)
+			if err := retryDelay(ctx); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			continue
+		}
+
+		var guestDescriptionObject guestResponse
+		allOK := guestSignal.StatusCode == http.StatusOK
+		if allOK {
+			// debug logging
+			fmt.Fprintf(os.Stderr,
+				"conn %d: decoding the guest signal!\n",
+				peerIndex)
+			err = decodeSignalResponse(guestSignal.Body, &guestDescriptionObject)
+
+			// debug logging
+			fmt.Fprintf(os.Stderr,
+				"conn %d: have decoded the guest signal!\n",
+				peerIndex)
+		}
+		guestSignal.Body.Close()
+
+		if allOK {
+			if err != nil {
+				signalClient.fail(peerIndex,
+					"while decoding the guest signal",
+					err)
+				if err := retryDelay(ctx); err != nil {
+					return webrtc.SessionDescription{}, err
+				}
+				continue
+			}
+
+			guestDescription := guestDescriptionObject.GuestDescription
+			if guestDescription != "" {
+				// debug logging
+				fmt.Fprintf(os.Stderr,
+					"conn %d: the guest has apparently signalled!\n",
+					peerIndex)
+
+				guestAnswer := webrtc.SessionDescription{}
+				err = signalClient.decode(hostId,
+					webrtc.SDPTypeAnswer,
+					guestDescription,
+					&guestAnswer)
+				if err != nil {
+					signalClient.fail(peerIndex,
+						"while decoding the guest description",
+						err)
+					if err := retryDelay(ctx); err != nil {
+						return webrtc.SessionDescription{}, err
+					}
+					continue
+				}
+
+				return guestAnswer, nil
+			}
+
+			fmt.Fprintf(os.Stderr,
+				"conn %d: the guest has not signalled yet\n",
+				peerIndex)
+			if err := retryDelay(ctx); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+		} else {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: first need to create the host\n",
+				peerIndex)
+
+			err := signalClient.HostSetup(ctx,
+				hostId,
+				peerLocalSessionDescription,
+				peerIndex)
+			if err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+		}
+	}
+}
+
+// Encode a SessionDescription for the signalling server, sealed when a
+// room secret is configured.
+func (signalClient *Client) encode(hostId string, obj *webrtc.SessionDescription) (string, error) {
+	if signalClient.sealer != nil {
+		return signalClient.sealer.seal(hostId, obj)
+	}
+
+	return encode(obj), nil
+}
+
+// Decode a SessionDescription from the signalling server, which has to be
+// sealed when a room secret is configured.
+func (signalClient *Client) decode(hostId string,
+	sdpType webrtc.SDPType,
+	in string,
+	obj *webrtc.SessionDescription) error {
+
+	if signalClient.sealer != nil {
+		return signalClient.sealer.open(hostId, sdpType, in, obj)
+	}
+
+	return decode(in, obj)
+}
+
+// JSON encode + base64 a SessionDescription.
+func encode(obj *webrtc.SessionDescription) string {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Decode a base64 and unmarshal JSON into a SessionDescription.
+func decode(in string, obj *webrtc.SessionDescription) error {
+	b, err := base64.StdEncoding.DecodeString(in)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, obj)
+}
@@ -0,0 +1,160 @@
+package signalling
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// {"type":"offer","sdp":"v=0\r\n"} and its answer, base64
+const (
+	offerDescription  = "eyJ0eXBlIjoib2ZmZXIiLCJzZHAiOiJ2PTBcclxuIn0="
+	answerDescription = "eyJ0eXBlIjoiYW5zd2VyIiwic2RwIjoidj0wXHJcbiJ9"
+)
+
+var (
+	offer  = webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0\r\n"}
+	answer = webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "v=0\r\n"}
+)
+
+// One exchange with the signalling server, as it goes over the wire.
+type golden struct {
+	method      string
+	requestURI  string
+	requestBody string
+
+	responseBody string
+}
+
+// A signalling server that expects exactly the golden exchange.
+func newGoldenServer(t *testing.T, exchange golden) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			t.Errorf("reading the request - %s", err)
+		}
+
+		if request.Method != exchange.method {
+			t.Errorf("method %s, want %s", request.Method, exchange.method)
+		}
+		if request.RequestURI != exchange.requestURI {
+			t.Errorf("request uri %s, want %s", request.RequestURI, exchange.requestURI)
+		}
+		if string(body) != exchange.requestBody {
+			t.Errorf("request body\n%s\nwant\n%s", body, exchange.requestBody)
+		}
+
+		writer.Write([]byte(exchange.responseBody))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, Options{AllowInsecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func TestHostSetup(t *testing.T) {
+	client := newGoldenServer(t, golden{
+		method:      http.MethodPost,
+		requestURI:  "/api/host",
+		requestBody: `{"id":"room","description":"` + offerDescription + `"}`,
+	})
+
+	if err := client.HostSetup(context.Background(), "room", offer, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForHost(t *testing.T) {
+	client := newGoldenServer(t, golden{
+		method:       http.MethodGet,
+		requestURI:   "/api/host?id=room",
+		responseBody: `{"description":"` + offerDescription + `"}`,
+	})
+
+	description, err := client.WaitForHost(context.Background(), "room", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description != offer {
+		t.Errorf("host description %+v, want %+v", description, offer)
+	}
+}
+
+func TestGuestSetup(t *testing.T) {
+	client := newGoldenServer(t, golden{
+		method:      http.MethodPost,
+		requestURI:  "/api/guest",
+		requestBody: `{"hostId":"room","guestDescription":"` + answerDescription + `"}`,
+	})
+
+	if err := client.GuestSetup(context.Background(), "room", answer, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForGuest(t *testing.T) {
+	client := newGoldenServer(t, golden{
+		method:       http.MethodGet,
+		requestURI:   "/api/guest?hostId=room",
+		responseBody: `{"guestDescription":"` + answerDescription + `"}`,
+	})
+
+	description, err := client.WaitForGuest(context.Background(), "room", 0, offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description != answer {
+		t.Errorf("guest description %+v, want %+v", description, answer)
+	}
+}
+
+func TestWaitForGuestCancelled(t *testing.T) {
+	client := newGoldenServer(t, golden{
+		method:       http.MethodGet,
+		requestURI:   "/api/guest?hostId=room",
+		responseBody: `{"guestDescription":""}`,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.WaitForGuest(ctx, "room", 0, offer); err != context.DeadlineExceeded {
+		t.Errorf("error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDecodeSignalResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"valid", `{"description":"abc"}`, false},
+		{"trailing whitespace", "{\"description\":\"abc\"}\n", false},
+		{"non-string field", `{"description":5}`, true},
+		{"trailing data", `{"description":"abc"} {"description":"def"}`, true},
+		{"trailing garbage", `{"description":"abc"}x`, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var response hostResponse
+			err := decodeSignalResponse(strings.NewReader(test.body), &response)
+			if (err != nil) != test.wantErr {
+				t.Errorf("error %v, want an error %t", err, test.wantErr)
+			}
+		})
+	}
+}
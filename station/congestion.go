@@ -0,0 +1,183 @@
+package station
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+const (
+	// where the send side estimate of a new peer starts
+	initialBandwidthEstimate = 1_000_000
+	minBandwidthEstimate     = 100_000
+
+	bitrateFeedbackInterval = 1 * time.Second
+)
+
+// The webrtc API for one peer connection, with the pion defaults plus a
+// send side bandwidth estimator: the peer reports the arrival of every
+// packet with transport wide congestion control feedback and Google
+// congestion control turns it into a target bitrate.
+//
+// The estimator only measures, it does not pace the packets, the rate is
+// up to the encoder of the local media.
+func newEstimatingAPI() (*webrtc.API, chan cc.BandwidthEstimator, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, err
+	}
+
+	registry := &interceptor.Registry{}
+
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBandwidthEstimate),
+			gcc.SendSideBWEMinBitrate(minBandwidthEstimate),
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	estimatorChannel := make(chan cc.BandwidthEstimator, 1)
+	congestionController.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
+		estimatorChannel <- estimator
+	})
+	registry.Add(congestionController)
+
+	if err = webrtc.ConfigureTWCCHeaderExtensionSender(mediaEngine, registry); err != nil {
+		return nil, nil, err
+	}
+
+	if err = webrtc.RegisterDefaultInterceptors(mediaEngine, registry); err != nil {
+		return nil, nil, err
+	}
+
+	return webrtc.NewAPI(
+			webrtc.WithMediaEngine(mediaEngine),
+			webrtc.WithInterceptorRegistry(registry)),
+		estimatorChannel,
+		nil
+}
+
+type bitratePeer struct {
+	Peer    int `json:"peer"`
+	Bitrate int `json:"bitrate"`
+}
+
+// What the local encoder gets, bitrates in bits per second.
+type bitrateFeedback struct {
+	// the lowest estimate, so that every peer can take the stream
+	TargetBitrate int           `json:"targetBitrate"`
+	Peers         []bitratePeer `json:"peers"`
+}
+
+// Tell the encoder of the local media the bitrate the peers can take, once
+// a second:
+//
+//	udp:[host:]port - a JSON datagram
+//	file:path - a JSON file, replaced as a whole
+//
+// The reports stop when ctx is done.
+func reportTargetBitrate(ctx context.Context, peers *[]Peer, mutex *sync.Mutex, spec string) error {
+	kind, argument, _ := strings.Cut(spec, ":")
+
+	var write func(data []byte) error
+	closeWriter := func() {}
+
+	switch kind {
+	case "udp":
+		if !strings.Contains(argument, ":") {
+			argument = "127.0.0.1:" + argument
+		}
+
+		connection, err := net.Dial("udp", argument)
+		if err != nil {
+			return err
+		}
+		closeWriter = func() { connection.Close() }
+
+		write = func(data []byte) error {
+			_, err := connection.Write(data)
+
+			// nobody is listening on the port yet
+			var opError *net.OpError
+			if errors.As(err, &opError) &&
+				opError.Err.Error() == "write: connection refused" {
+				return nil
+			}
+
+			return err
+		}
+	case "file":
+		write = func(data []byte) error {
+			return media.ReplaceFile(argument, append(data, '\n'))
+		}
+	default:
+		return fmt.Errorf("unknown bitrate feedback %q", spec)
+	}
+
+	go func() {
+		defer closeWriter()
+
+		ticker := time.NewTicker(bitrateFeedbackInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			feedback := bitrateFeedback{Peers: []bitratePeer{}}
+
+			mutex.Lock()
+			for peerIndex, peer := range *peers {
+				// the estimate of a peer that is not connected yet or gets
+				// no local media is still the initial one
+				if peer.peerConnection == nil || peer.bandwidthEstimator == nil ||
+					!peer.joined || peer.sender == nil {
+					continue
+				}
+
+				bitrate := peer.bandwidthEstimator.GetTargetBitrate()
+				feedback.Peers = append(feedback.Peers, bitratePeer{peerIndex, bitrate})
+
+				if feedback.TargetBitrate == 0 || bitrate < feedback.TargetBitrate {
+					feedback.TargetBitrate = bitrate
+				}
+			}
+			mutex.Unlock()
+
+			// no peers, nothing to follow
+			if len(feedback.Peers) == 0 {
+				continue
+			}
+
+			data, err := json.Marshal(feedback)
+			if err != nil {
+				panic(fmt.Sprintf("logic: json.Marshal of bitrate feedback - %s", err))
+			}
+
+			if err = write(data); err != nil {
+				fmt.Fprintf(os.Stderr, "bitrate feedback: %s\n", err)
+			}
+		}
+	}()
+
+	return nil
+}
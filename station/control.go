@@ -0,0 +1,344 @@
+package station
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operates the running station over HTTP:
+//
+//	GET    /peers            the open peer connections and their states
+//	DELETE /peers/{peer}     close a peer connection
+//	GET    /peers/{peer}/sdp the local and the remote description of a peer
+//	GET    /on-air           the guest whose media goes to the sinks
+//	PUT    /on-air           {"peer": 3} picks that guest, -1 takes all off
+//	GET    /tracks           the local tracks and whether they are muted
+//	POST   /tracks           {"name": "screen", "source": "udp:5008"} adds a video
+//	PUT    /tracks/{name}    {"muted": true} holds a track back from the peers
+//	DELETE /tracks/{name}    removes a video added over POST
+//	GET    /host-id          the room of the signalling loop
+//	PUT    /host-id          {"hostId": "..."} moves the waiting peer connection
+//
+// It has no authentication, it listens on a loopback address or a Unix
+// socket unless told otherwise.
+type controlServer struct {
+	station *Station
+}
+
+func newControlServer(station *Station) *controlServer {
+	return &controlServer{station: station}
+}
+
+func (server *controlServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /peers", server.listPeers)
+	mux.HandleFunc("DELETE /peers/{peer}", server.kickPeer)
+	mux.HandleFunc("GET /peers/{peer}/sdp", server.peerDescriptions)
+	mux.HandleFunc("GET /on-air", server.getOnAir)
+	mux.HandleFunc("PUT /on-air", server.putOnAir)
+	mux.HandleFunc("GET /tracks", server.listTracks)
+	mux.HandleFunc("POST /tracks", server.addTrack)
+	mux.HandleFunc("PUT /tracks/{name}", server.putTrack)
+	mux.HandleFunc("DELETE /tracks/{name}", server.removeTrack)
+	mux.HandleFunc("GET /host-id", server.getHostID)
+	mux.HandleFunc("PUT /host-id", server.putHostID)
+}
+
+// Listen on "unix:path" or "[host]:port", a TCP address has to be a
+// loopback one unless allowRemote.
+func listenControl(address string, allowRemote bool) (net.Listener, error) {
+	if path, found := strings.CutPrefix(address, "unix:"); found {
+		// a socket left behind by an earlier run
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowRemote && host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("%s is not a loopback address, the control API has no authentication", address)
+		}
+	}
+
+	return net.Listen("tcp", address)
+}
+
+type controlPeer struct {
+	Peer       int    `json:"peer"`
+	Connection string `json:"connection"`
+	ICE        string `json:"ice"`
+	Signalling string `json:"signalling"`
+	OnAir      bool   `json:"onAir"`
+	// local packets waiting for the peer, -1 when it gets no local media
+	QueueDepth int `json:"queueDepth"`
+}
+
+func (server *controlServer) listPeers(writer http.ResponseWriter, request *http.Request) {
+	peers := []controlPeer{}
+
+	server.station.mutex.Lock()
+	onAir := server.station.peerOptions.onAir.current()
+	for peerIndex, peer := range server.station.peers {
+		if peer.peerConnection == nil {
+			continue
+		}
+
+		queueDepth := -1
+		if peer.sender != nil {
+			queueDepth = peer.sender.depth()
+		}
+
+		peers = append(peers, controlPeer{
+			Peer:       peerIndex,
+			Connection: peer.peerConnection.ConnectionState().String(),
+			ICE:        peer.peerConnection.ICEConnectionState().String(),
+			Signalling: peer.peerConnection.SignalingState().String(),
+			OnAir:      peerIndex == onAir,
+			QueueDepth: queueDepth,
+		})
+	}
+	server.station.mutex.Unlock()
+
+	writeJSON(writer, peers)
+}
+
+// The index in the path of a peer that is still open, nil after an error
+// reply. The caller holds the mutex of the station.
+func (server *controlServer) openPeer(writer http.ResponseWriter, request *http.Request) (int, *Peer) {
+	peerIndex, err := strconv.Atoi(request.PathValue("peer"))
+	if err != nil || peerIndex < 0 || peerIndex >= len(server.station.peers) ||
+		server.station.peers[peerIndex].peerConnection == nil {
+		http.Error(writer, "no such open peer", http.StatusNotFound)
+		return 0, nil
+	}
+
+	return peerIndex, &server.station.peers[peerIndex]
+}
+
+func (server *controlServer) kickPeer(writer http.ResponseWriter, request *http.Request) {
+	server.station.mutex.Lock()
+	defer server.station.mutex.Unlock()
+
+	peerIndex, peer := server.openPeer(writer, request)
+	if peer == nil {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "conn %d: closed over the control api\n", peerIndex)
+	peer.Close(peerIndex)
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *controlServer) peerDescriptions(writer http.ResponseWriter, request *http.Request) {
+	var descriptions struct {
+		Local  string `json:"local"`
+		Remote string `json:"remote"`
+	}
+
+	server.station.mutex.Lock()
+	_, peer := server.openPeer(writer, request)
+	if peer != nil {
+		if local := peer.peerConnection.LocalDescription(); local != nil {
+			descriptions.Local = local.SDP
+		}
+		if remote := peer.peerConnection.RemoteDescription(); remote != nil {
+			descriptions.Remote = remote.SDP
+		}
+	}
+	server.station.mutex.Unlock()
+
+	if peer != nil {
+		writeJSON(writer, descriptions)
+	}
+}
+
+type controlOnAir struct {
+	// -1 when no guest is on air, a pointer so that a missing one is an
+	// error instead of peer 0
+	Peer *int `json:"peer"`
+}
+
+func (server *controlServer) getOnAir(writer http.ResponseWriter, request *http.Request) {
+	server.station.mutex.Lock()
+	peer := server.station.peerOptions.onAir.current()
+	server.station.mutex.Unlock()
+
+	onAir := controlOnAir{Peer: &peer}
+
+	writeJSON(writer, onAir)
+}
+
+func (server *controlServer) putOnAir(writer http.ResponseWriter, request *http.Request) {
+	var onAir controlOnAir
+	err := json.NewDecoder(request.Body).Decode(&onAir)
+	if err == nil && onAir.Peer == nil {
+		err = errors.New("no peer")
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server.station.mutex.Lock()
+	err = server.station.peerOptions.onAir.putOnAir(*onAir.Peer)
+	server.station.mutex.Unlock()
+
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+type controlTrack struct {
+	Name  string `json:"name"`
+	Muted bool   `json:"muted"`
+}
+
+func (server *controlServer) listTracks(writer http.ResponseWriter, request *http.Request) {
+	server.station.mutex.Lock()
+	names := make([]string, 0, len(server.station.mutes))
+	for name := range server.station.mutes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tracks := []controlTrack{}
+	for _, name := range names {
+		tracks = append(tracks, controlTrack{
+			Name:  name,
+			Muted: server.station.mutes[name].muted.Load(),
+		})
+	}
+	server.station.mutex.Unlock()
+
+	writeJSON(writer, tracks)
+}
+
+type controlNewTrack struct {
+	Name string `json:"name"`
+	// a video source, like -extra-video-source takes
+	Source string `json:"source"`
+}
+
+func (server *controlServer) addTrack(writer http.ResponseWriter, request *http.Request) {
+	var track controlNewTrack
+	err := json.NewDecoder(request.Body).Decode(&track)
+	if err == nil && (track.Name == "" || track.Source == "") {
+		err = errors.New("a track needs a name and a source")
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = server.station.AddVideo(track.Name, track.Source)
+	if errors.Is(err, errTrackExists) {
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *controlServer) removeTrack(writer http.ResponseWriter, request *http.Request) {
+	err := server.station.RemoveVideo(request.PathValue("name"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *controlServer) putTrack(writer http.ResponseWriter, request *http.Request) {
+	name := request.PathValue("name")
+
+	server.station.mutex.Lock()
+	mute, found := server.station.mutes[name]
+	server.station.mutex.Unlock()
+	if !found {
+		http.Error(writer, "no such local track", http.StatusNotFound)
+		return
+	}
+
+	var track controlTrack
+	if err := json.NewDecoder(request.Body).Decode(&track); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mute.muted.Store(track.Muted)
+	fmt.Fprintf(os.Stderr, "control: %s track muted - %t\n", name, track.Muted)
+
+	writeJSON(writer, controlTrack{Name: name, Muted: track.Muted})
+}
+
+type controlHostID struct {
+	HostID string `json:"hostId"`
+}
+
+func (server *controlServer) getHostID(writer http.ResponseWriter, request *http.Request) {
+	server.station.mutex.Lock()
+	hostID := controlHostID{HostID: server.station.hostID}
+	server.station.mutex.Unlock()
+
+	writeJSON(writer, hostID)
+}
+
+// The peer connection waiting for the signalling server starts over in the
+// new room, the connected ones stay.
+func (server *controlServer) putHostID(writer http.ResponseWriter, request *http.Request) {
+	if server.station.signalClient == nil {
+		http.Error(writer, "the station has no signalling server", http.StatusConflict)
+		return
+	}
+
+	var hostID controlHostID
+	err := json.NewDecoder(request.Body).Decode(&hostID)
+	if err == nil && hostID.HostID == "" {
+		err = errors.New("empty host id")
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server.station.mutex.Lock()
+	server.station.hostID = hostID.HostID
+	if server.station.cancelSignal != nil {
+		server.station.cancelSignal()
+	}
+	server.station.mutex.Unlock()
+
+	fmt.Fprintf(os.Stderr, "control: host id changed, signalling starts over in the new room\n")
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(writer http.ResponseWriter, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		fmt.Fprintf(os.Stderr, "control: writing the reply - %s\n", err)
+	}
+}
@@ -0,0 +1,112 @@
+package station
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// A running station without peers behind the control API.
+func newControlTestServer(t *testing.T) (*Station, *httptest.Server) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	station := &Station{
+		mutes:             map[string]*trackMute{},
+		addedVideoStreams: map[string]addedVideoStream{},
+		runCtx:            ctx,
+	}
+	station.peerOptions.Video = true
+	station.peerOptions.Direction = webrtc.RTPTransceiverDirectionSendrecv
+
+	mux := http.NewServeMux()
+	newControlServer(station).register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return station, server
+}
+
+func controlRequest(t *testing.T, server *httptest.Server, method string, path string, body string) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response
+}
+
+func controlTrackNames(t *testing.T, server *httptest.Server) []string {
+	t.Helper()
+
+	var tracks []controlTrack
+	response := controlRequest(t, server, http.MethodGet, "/tracks", "")
+	if err := json.NewDecoder(response.Body).Decode(&tracks); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, track := range tracks {
+		names = append(names, track.Name)
+	}
+
+	return names
+}
+
+func TestControlAddRemoveTrack(t *testing.T) {
+	station, server := newControlTestServer(t)
+
+	response := controlRequest(t, server, http.MethodPost, "/tracks", `{"name":"screen","source":"test"}`)
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST /tracks status %d, want %d", response.StatusCode, http.StatusNoContent)
+	}
+	if names := controlTrackNames(t, server); len(names) != 1 || names[0] != "screen" {
+		t.Fatalf("tracks %v, want [screen]", names)
+	}
+
+	response = controlRequest(t, server, http.MethodPost, "/tracks", `{"name":"screen","source":"test"}`)
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("second POST /tracks status %d, want %d", response.StatusCode, http.StatusConflict)
+	}
+
+	station.mutex.Lock()
+	stream := station.addedVideoStreams["screen"]
+	station.mutex.Unlock()
+
+	response = controlRequest(t, server, http.MethodDelete, "/tracks/screen", "")
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE /tracks/screen status %d, want %d", response.StatusCode, http.StatusNoContent)
+	}
+
+	// nothing writes to the removed track any more
+	select {
+	case <-stream.done:
+	case <-time.After(time.Second):
+		t.Fatal("the removed video is still streaming")
+	}
+
+	if names := controlTrackNames(t, server); len(names) != 0 {
+		t.Errorf("tracks %v after the removal, want none", names)
+	}
+
+	response = controlRequest(t, server, http.MethodDelete, "/tracks/screen", "")
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE /tracks/screen status %d, want %d", response.StatusCode, http.StatusNotFound)
+	}
+}
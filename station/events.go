@@ -0,0 +1,120 @@
+package station
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	// the ICE connection of a peer is up
+	EventPeerJoined EventType = "peer-joined"
+	// a peer disconnected, failed or was closed
+	EventPeerLeft EventType = "peer-left"
+	// every change of the ICE connection state of a peer, in State
+	EventICEStateChanged EventType = "ice-state-changed"
+	// a remote track of a peer goes to its sinks, described in Track
+	EventTrackStarted EventType = "track-started"
+	// a peer sent a message over the data channel, in Data
+	EventDataMessage EventType = "data-message"
+	// another guest went on air, -1 in Peer when none is
+	EventOnAirChanged EventType = "on-air-changed"
+	// an exchange with the signalling server failed and is tried again,
+	// in Error
+	EventSignallingError EventType = "signalling-error"
+)
+
+// Something that happened to the station, see Station.Subscribe.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// index of the peer connection, like in the "conn %d" logs
+	Peer int `json:"peer"`
+
+	State string `json:"state,omitempty"`
+	Track string `json:"track,omitempty"`
+	Data  string `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// events that came while Options.OnEvent is busy wait here
+const onEventQueueLength = 256
+
+// Hands the events to the subscribers, and to Options.OnEvent as one of
+// them.
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[int]chan Event
+	next        int
+}
+
+func newEventBus(onEvent func(Event)) *eventBus {
+	bus := &eventBus{
+		subscribers: map[int]chan Event{},
+	}
+
+	// the events are published with the mutex of the peers held, a
+	// callback that calls back into the station would wait for itself
+	if onEvent != nil {
+		events, _ := bus.subscribe(onEventQueueLength)
+		go func() {
+			for event := range events {
+				onEvent(event)
+			}
+		}()
+	}
+
+	return bus
+}
+
+func (bus *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+
+	bus.mutex.Lock()
+	id := bus.next
+	bus.next++
+	bus.subscribers[id] = events
+	bus.mutex.Unlock()
+
+	unsubscribe := func() {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+
+		if _, ok := bus.subscribers[id]; ok {
+			delete(bus.subscribers, id)
+			close(events)
+		}
+	}
+
+	return events, unsubscribe
+}
+
+// Called from the pion callbacks, so a subscriber that does not keep up
+// loses events instead of holding up the peers.
+func (bus *eventBus) publish(event Event) {
+	event.Time = time.Now()
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for _, events := range bus.subscribers {
+		select {
+		case events <- event:
+		default:
+			fmt.Fprintf(os.Stderr,
+				"conn %d: dropping the %s event, a subscriber is behind\n",
+				event.Peer,
+				event.Type)
+		}
+	}
+}
+
+// Publish an event of the peers, when the options have a bus.
+func (options *PeerOptions) emit(event Event) {
+	if options.events != nil {
+		options.events.publish(event)
+	}
+}
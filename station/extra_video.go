@@ -0,0 +1,194 @@
+package station
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+// A local video besides the camera, like a screen share. It goes out as a
+// track of its own, with the name as its track id, in the media stream of
+// the station.
+type extraVideo struct {
+	name  string
+	codec webrtc.RTPCodecCapability
+	ssrc  webrtc.SSRC
+	// bound to all the peer connections, nil gives every peer a track of
+	// its own
+	shared *webrtc.TrackLocalStaticRTP
+}
+
+func newExtraVideo(name string, codec webrtc.RTPCodecCapability) *extraVideo {
+	return &extraVideo{
+		name:  name,
+		codec: codec,
+		ssrc:  webrtc.SSRC(media.RandomSSRC()),
+	}
+}
+
+// Parse "name=spec" of -extra-video-source and -extra-video-sink.
+func parseExtraVideo(value string) (string, string, error) {
+	name, spec, found := strings.Cut(value, "=")
+	if !found || name == "" || spec == "" {
+		return "", "", fmt.Errorf("expecting name=spec, got %q", value)
+	}
+
+	return name, spec, nil
+}
+
+// The names of the remote videos with sinks of their own, in order.
+func extraVideoSinkNames(sinks map[string][]string) []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// The goroutine that streams a video added while running.
+type addedVideoStream struct {
+	stop context.CancelFunc
+	// closed when the goroutine returns
+	done chan struct{}
+}
+
+var (
+	errTrackExists  = errors.New("a local track has that name")
+	errNoAddedVideo = errors.New("no video of that name was added while running")
+)
+
+// Add a local video while the station runs, like a screen share that
+// starts, from a source spec like the ones of -extra-video-source. The
+// connected peers renegotiate to get it, the new ones get it from the
+// start. Its track is shared by all the peers and it can be muted like the
+// others.
+func (station *Station) AddVideo(name string, spec string) error {
+	peerOptions := &station.peerOptions
+	if !peerOptions.Video || peerOptions.Direction == webrtc.RTPTransceiverDirectionRecvonly {
+		return errors.New("the station sends no video")
+	}
+	if name == "" {
+		return errors.New("empty track name")
+	}
+
+	source, err := media.NewSource(media.Video, spec, station.options.H264FrameRate)
+	if err != nil {
+		return err
+	}
+
+	added := newExtraVideo(name, source.Codec())
+	added.shared, err = webrtc.NewTrackLocalStaticRTP(added.codec, name, peerOptions.StreamID)
+	if err != nil {
+		source.Close()
+		return err
+	}
+
+	station.mutex.Lock()
+	defer station.mutex.Unlock()
+
+	if station.runCtx == nil || station.runCtx.Err() != nil {
+		source.Close()
+		return errors.New("the station is not running")
+	}
+	if _, found := station.mutes[name]; found {
+		source.Close()
+		return errTrackExists
+	}
+
+	mute := &trackMute{}
+	station.mutes[name] = mute
+	peerOptions.addedVideos = append(slices.Clip(peerOptions.addedVideos), added)
+
+	for peerIndex := range station.peers {
+		peer := &station.peers[peerIndex]
+		if peer.peerConnection == nil {
+			continue
+		}
+
+		rtpSender, err := addVideo(peer.peerConnection, peerOptions.Direction, added)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "conn %d: adding the %s track - %s\n", peerIndex, name, err)
+			continue
+		}
+		if peer.addedVideoSenders == nil {
+			peer.addedVideoSenders = map[string]*webrtc.RTPSender{}
+		}
+		peer.addedVideoSenders[name] = rtpSender
+
+		go readFeedback(rtpSender, peer.videoLayers)
+	}
+
+	ctx, stop := context.WithCancel(station.runCtx)
+	stream := addedVideoStream{stop: stop, done: make(chan struct{})}
+	station.addedVideoStreams[name] = stream
+	go func() {
+		defer close(stream.done)
+		streamLocalTrack(ctx, &station.peers, &station.mutex, media.Video,
+			nil, 0, -1,
+			added.shared,
+			mute,
+			source)
+	}()
+
+	fmt.Fprintf(os.Stderr, "%s: video added\n", name)
+
+	return nil
+}
+
+// Stop a video added with AddVideo, the connected peers renegotiate
+// without it. It returns once nothing writes to the track any more.
+func (station *Station) RemoveVideo(name string) error {
+	peerOptions := &station.peerOptions
+
+	station.mutex.Lock()
+
+	stream, found := station.addedVideoStreams[name]
+	if !found {
+		station.mutex.Unlock()
+		return errNoAddedVideo
+	}
+
+	stream.stop()
+	delete(station.addedVideoStreams, name)
+	delete(station.mutes, name)
+	peerOptions.addedVideos = slices.DeleteFunc(slices.Clone(peerOptions.addedVideos),
+		func(added *extraVideo) bool {
+			return added.name == name
+		})
+
+	for peerIndex := range station.peers {
+		peer := &station.peers[peerIndex]
+
+		rtpSender, found := peer.addedVideoSenders[name]
+		if !found {
+			continue
+		}
+		delete(peer.addedVideoSenders, name)
+
+		if peer.peerConnection == nil {
+			continue
+		}
+		if err := peer.peerConnection.RemoveTrack(rtpSender); err != nil {
+			fmt.Fprintf(os.Stderr, "conn %d: removing the %s track - %s\n", peerIndex, name, err)
+		}
+	}
+
+	station.mutex.Unlock()
+
+	// the stream may be writing a packet, with the mutex for its peers
+	<-stream.done
+
+	fmt.Fprintf(os.Stderr, "%s: video removed\n", name)
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package station
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+const (
+	// packets waiting for a peer, about a second of video at 4 Mbit/s
+	peerQueueLength = 512
+	localPacketMTU  = 1600
+)
+
+// A packet of a local source on its way to the peers. It is parsed once
+// and shared by all the peer queues, the last one done with it gives it
+// back to the pool.
+type localPacket struct {
+	packet     rtp.Packet
+	buffer     [localPacketMTU]byte
+	references atomic.Int32
+}
+
+var localPacketPool = sync.Pool{
+	New: func() any {
+		return &localPacket{}
+	},
+}
+
+func newLocalPacket() *localPacket {
+	packet := localPacketPool.Get().(*localPacket)
+	packet.references.Store(1)
+
+	return packet
+}
+
+func (packet *localPacket) retain() {
+	packet.references.Add(1)
+}
+
+func (packet *localPacket) release() {
+	if packet.references.Add(-1) == 0 {
+		packet.packet = rtp.Packet{}
+		localPacketPool.Put(packet)
+	}
+}
+
+// Implemented by the sources that can read straight into the buffer of a
+// local packet, instead of a packet of their own.
+type bufferedSource interface {
+	ReadRTPInto(buffer []byte, packet *rtp.Packet) error
+}
+
+func readLocalPacket(source media.Source, packet *localPacket) error {
+	if buffered, ok := source.(bufferedSource); ok {
+		return buffered.ReadRTPInto(packet.buffer[:], &packet.packet)
+	}
+
+	// the packets of the other sources are not reused, they can be shared
+	// as they are
+	sourcePacket, err := source.ReadRTP()
+	if err != nil {
+		return err
+	}
+	packet.packet = *sourcePacket
+
+	return nil
+}
+
+// Queue a packet for every peer that gets the local media. The senders are
+// taken under the mutex and written to after it, the slice is reused from
+// packet to packet.
+func fanOut(peers *[]Peer,
+	mutex *sync.Mutex,
+	senders []*peerSender,
+	mediaType media.Type,
+	layerIndex int,
+	extraIndex int,
+	packet *localPacket) []*peerSender {
+
+	senders = senders[:0]
+
+	mutex.Lock()
+	for _, peer := range *peers {
+		if peer.sender != nil {
+			senders = append(senders, peer.sender)
+		}
+	}
+	mutex.Unlock()
+
+	for _, sender := range senders {
+		sender.enqueue(mediaType, layerIndex, extraIndex, packet)
+	}
+
+	return senders
+}
+
+type queuedPacket struct {
+	mediaType  media.Type
+	layerIndex int
+	// an extra video, -1 for the camera and the audio
+	extraIndex int
+	packet     *localPacket
+}
+
+// Writes the local media to the tracks of one peer from a goroutine of its
+// own, so that a slow peer does not hold up the others. When its queue is
+// full the peer misses packets, and when it stays behind for longer than
+// slowTimeout it is let go.
+type peerSender struct {
+	peerIndex   int
+	audioTrack  *webrtc.TrackLocalStaticRTP
+	videoTrack  *webrtc.TrackLocalStaticRTP
+	videoLayers *layerSelector
+	// indexed like PeerOptions.ExtraVideos
+	extraVideoTracks []*webrtc.TrackLocalStaticRTP
+
+	queue    chan queuedPacket
+	done     chan struct{}
+	stopOnce sync.Once
+	// a fan-out that took the sender before it stopped queues nothing
+	stopped atomic.Bool
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	// unix nanoseconds of the first drop since the queue was last half
+	// empty, 0 when the peer keeps up
+	behindSince atomic.Int64
+	slowTimeout time.Duration
+	onSlow      func()
+	slowOnce    sync.Once
+}
+
+func newPeerSender(peerIndex int,
+	audioTrack *webrtc.TrackLocalStaticRTP,
+	videoTrack *webrtc.TrackLocalStaticRTP,
+	extraVideoTracks []*webrtc.TrackLocalStaticRTP,
+	videoLayers *layerSelector,
+	slowTimeout time.Duration,
+	onSlow func()) *peerSender {
+
+	sender := &peerSender{
+		peerIndex:   peerIndex,
+		audioTrack:  audioTrack,
+		videoTrack:  videoTrack,
+		videoLayers: videoLayers,
+		queue:       make(chan queuedPacket, peerQueueLength),
+		done:        make(chan struct{}),
+		slowTimeout: slowTimeout,
+		onSlow:      onSlow,
+
+		extraVideoTracks: extraVideoTracks,
+	}
+
+	go sender.run()
+
+	return sender
+}
+
+// Queue a packet for the peer, the sender releases it when it is written
+// or dropped.
+func (sender *peerSender) enqueue(mediaType media.Type, layerIndex int, extraIndex int, packet *localPacket) {
+	if sender.stopped.Load() {
+		return
+	}
+
+	packet.retain()
+
+	select {
+	case sender.queue <- queuedPacket{mediaType, layerIndex, extraIndex, packet}:
+	default:
+		packet.release()
+		sender.dropped.Add(1)
+		sender.fallBehind()
+	}
+}
+
+func (sender *peerSender) fallBehind() {
+	now := time.Now().UnixNano()
+	if sender.behindSince.CompareAndSwap(0, now) {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: send queue full, dropping packets\n",
+			sender.peerIndex)
+		return
+	}
+
+	behind := time.Duration(now - sender.behindSince.Load())
+	if sender.slowTimeout > 0 && behind > sender.slowTimeout {
+		sender.slowOnce.Do(func() {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: behind for %s, disconnecting\n",
+				sender.peerIndex,
+				behind.Round(time.Millisecond))
+			go sender.onSlow()
+		})
+	}
+}
+
+func (sender *peerSender) catchUp() {
+	if len(sender.queue) > cap(sender.queue)/2 {
+		return
+	}
+
+	if sender.behindSince.Swap(0) != 0 {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: send queue caught up, %d packets dropped so far\n",
+			sender.peerIndex,
+			sender.dropped.Load())
+	}
+}
+
+// The packets waiting in the queue.
+func (sender *peerSender) depth() int {
+	return len(sender.queue)
+}
+
+func (sender *peerSender) stop() {
+	sender.stopOnce.Do(func() {
+		sender.stopped.Store(true)
+		close(sender.done)
+	})
+}
+
+func (sender *peerSender) run() {
+	var outbound rtp.Packet
+
+	for {
+		select {
+		case <-sender.done:
+			sender.drain()
+			return
+		case queued := <-sender.queue:
+			err := sender.write(queued, &outbound)
+			queued.packet.release()
+			sender.catchUp()
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr,
+					"conn %d: while write to track: %s\n",
+					sender.peerIndex,
+					err)
+
+				if errors.Is(err, io.ErrClosedPipe) {
+					sender.stop()
+				}
+			}
+		}
+	}
+}
+
+func (sender *peerSender) write(queued queuedPacket, outbound *rtp.Packet) error {
+	if queued.extraIndex >= 0 {
+		if queued.extraIndex >= len(sender.extraVideoTracks) {
+			return nil
+		}
+
+		sender.sent.Add(1)
+		return sender.extraVideoTracks[queued.extraIndex].WriteRTP(&queued.packet.packet)
+	}
+
+	// a session without the media
+	if (queued.mediaType == media.Audio && sender.audioTrack == nil) ||
+		(queued.mediaType == media.Video && sender.videoTrack == nil) {
+		return nil
+	}
+
+	if queued.mediaType == media.Audio {
+		sender.sent.Add(1)
+		return sender.audioTrack.WriteRTP(&queued.packet.packet)
+	}
+
+	if !sender.videoLayers.forward(queued.layerIndex, &queued.packet.packet, outbound) {
+		return nil
+	}
+
+	sender.sent.Add(1)
+	return sender.videoTrack.WriteRTP(outbound)
+}
+
+// Give the packets still queued back to the pool.
+func (sender *peerSender) drain() {
+	for {
+		select {
+		case queued := <-sender.queue:
+			queued.packet.release()
+		default:
+			return
+		}
+	}
+}
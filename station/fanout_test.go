@@ -0,0 +1,109 @@
+package station
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+// One local audio packet through fanOut to the queues of the peers and
+// their senders writing it to an unbound track.
+func BenchmarkFanout(b *testing.B) {
+	for _, peerCount := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("peers=%d", peerCount), func(b *testing.B) {
+			track, err := webrtc.NewTrackLocalStaticRTP(
+				webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus},
+				"audio",
+				"benchmark")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			var mutex sync.Mutex
+			peers := make([]Peer, peerCount)
+			for peerIndex := range peers {
+				peers[peerIndex].sender = newPeerSender(peerIndex,
+					track, nil, nil, nil, 0, func() {})
+			}
+			defer func() {
+				for _, peer := range peers {
+					peer.sender.stop()
+				}
+			}()
+
+			payload := make([]byte, 160)
+			var senders []*peerSender
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				packet := newLocalPacket()
+				packet.packet = rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						PayloadType:    111,
+						SequenceNumber: uint16(i),
+						Timestamp:      uint32(i) * 960,
+						SSRC:           1,
+					},
+					Payload: payload,
+				}
+
+				senders = fanOut(&peers, &mutex, senders, media.Audio, 0, -1, packet)
+				packet.release()
+
+				// the run loops keep up, so that it is their pace that
+				// is measured and not the packets dropped
+				if i%(peerQueueLength/2) == peerQueueLength/2-1 {
+					waitForSenders(peers)
+				}
+			}
+			waitForSenders(peers)
+
+			b.StopTimer()
+
+			var dropped uint64
+			for _, peer := range peers {
+				dropped += peer.sender.dropped.Load()
+			}
+			b.ReportMetric(float64(dropped)/float64(b.N*peerCount), "drops/packet")
+		})
+	}
+}
+
+func waitForSenders(peers []Peer) {
+	for _, peer := range peers {
+		for peer.sender.depth() != 0 {
+			runtime.Gosched()
+		}
+	}
+}
+
+// A fan-out that took the sender before it stopped does not fill its queue.
+func TestStoppedSenderQueuesNothing(t *testing.T) {
+	sender := newPeerSender(0, nil, nil, nil, nil, 0, func() {})
+	sender.stop()
+
+	packet := newLocalPacket()
+	for i := 0; i < 2*peerQueueLength; i++ {
+		sender.enqueue(media.Audio, 0, -1, packet)
+	}
+
+	if depth := sender.depth(); depth != 0 {
+		t.Errorf("%d packets queued for a stopped sender", depth)
+	}
+	if dropped := sender.dropped.Load(); dropped != 0 {
+		t.Errorf("%d packets dropped by a stopped sender", dropped)
+	}
+	if references := packet.references.Load(); references != 1 {
+		t.Errorf("%d references to the packet, want only the one of the fan-out", references)
+	}
+	packet.release()
+}
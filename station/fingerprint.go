@@ -0,0 +1,186 @@
+package station
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Load the station certificate from a PEM file, or generate one and store
+// it there, so that the DTLS fingerprint stays the same across restarts.
+func loadCertificate(path string) (*webrtc.Certificate, error) {
+	pems, err := os.ReadFile(path)
+	if err == nil {
+		return webrtc.CertificateFromPEM(string(pems))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader,
+		new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	// pion generates certificates for a month, a pinned identity
+	// has to live much longer than that
+	certificate, err := webrtc.NewCertificate(privateKey, x509.Certificate{
+		Issuer:       pkix.Name{CommonName: "meetupstation-pion"},
+		Subject:      pkix.Name{CommonName: "meetupstation-pion"},
+		NotBefore:    time.Now().AddDate(0, 0, -1),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		SerialNumber: serialNumber,
+		Version:      2,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := certificate.PEM()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
+
+// Format a fingerprint the way it appears in "a=fingerprint", with the
+// algorithm in lower case and the hex digits in upper case.
+func normalizeFingerprint(fingerprint string) string {
+	algorithm, value, found := strings.Cut(strings.TrimSpace(fingerprint), " ")
+	if !found {
+		return strings.ToUpper(algorithm)
+	}
+
+	return strings.ToLower(algorithm) + " " + strings.ToUpper(strings.TrimSpace(value))
+}
+
+func certificateFingerprints(certificate *webrtc.Certificate) ([]string, error) {
+	dtlsFingerprints, err := certificate.GetFingerprints()
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints []string
+	for _, dtlsFingerprint := range dtlsFingerprints {
+		fingerprints = append(fingerprints,
+			normalizeFingerprint(dtlsFingerprint.Algorithm+" "+dtlsFingerprint.Value))
+	}
+
+	return fingerprints, nil
+}
+
+// Collect the distinct session and media level "a=fingerprint" values.
+func descriptionFingerprints(description *webrtc.SessionDescription) ([]string, error) {
+	parsed, err := description.Unmarshal()
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprints []string
+	add := func(key string, value string) {
+		if key != "fingerprint" {
+			return
+		}
+
+		fingerprint := normalizeFingerprint(value)
+		for _, known := range fingerprints {
+			if known == fingerprint {
+				return
+			}
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	for _, attribute := range parsed.Attributes {
+		add(attribute.Key, attribute.Value)
+	}
+	for _, media := range parsed.MediaDescriptions {
+		for _, attribute := range media.Attributes {
+			add(attribute.Key, attribute.Value)
+		}
+	}
+
+	if len(fingerprints) == 0 {
+		return nil, errors.New("description has no fingerprint")
+	}
+
+	return fingerprints, nil
+}
+
+// Check that every fingerprint of the remote description is pinned. An
+// empty pin list accepts any description.
+func verifyFingerprints(description *webrtc.SessionDescription, pins []string) error {
+	if len(pins) == 0 {
+		return nil
+	}
+
+	fingerprints, err := descriptionFingerprints(description)
+	if err != nil {
+		return err
+	}
+
+	for _, fingerprint := range fingerprints {
+		pinned := false
+		for _, pin := range pins {
+			if normalizeFingerprint(pin) == fingerprint {
+				pinned = true
+				break
+			}
+		}
+
+		if !pinned {
+			return fmt.Errorf("remote fingerprint %s is not pinned", fingerprint)
+		}
+	}
+
+	return nil
+}
+
+// Derive a short authentication string from the fingerprints of both
+// sides. Both peers get the same digits, so reading them out over another
+// channel detects a signalling server that swapped the descriptions.
+func shortAuthenticationString(local *webrtc.SessionDescription,
+	remote *webrtc.SessionDescription) (string, error) {
+
+	localFingerprints, err := descriptionFingerprints(local)
+	if err != nil {
+		return "", err
+	}
+
+	remoteFingerprints, err := descriptionFingerprints(remote)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprints := append(localFingerprints, remoteFingerprints...)
+	sort.Strings(fingerprints)
+
+	digest := sha256.Sum256([]byte(strings.Join(fingerprints, "\n")))
+	code := binary.BigEndian.Uint32(digest[:4]) % 1000000
+
+	return fmt.Sprintf("%03d %03d", code/1000, code%1000), nil
+}
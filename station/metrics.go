@@ -0,0 +1,68 @@
+package station
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Serves the send queues of the peers in the Prometheus text format, so
+// that a slow viewer shows up before it is disconnected.
+type metricsServer struct {
+	peers *[]Peer
+	mutex *sync.Mutex
+}
+
+func newMetricsServer(peers *[]Peer, mutex *sync.Mutex) *metricsServer {
+	return &metricsServer{peers: peers, mutex: mutex}
+}
+
+func (server *metricsServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", server.metrics)
+}
+
+func (server *metricsServer) metrics(writer http.ResponseWriter, request *http.Request) {
+	type peerMetrics struct {
+		peerIndex int
+		depth     int
+		sent      uint64
+		dropped   uint64
+	}
+
+	var all []peerMetrics
+
+	server.mutex.Lock()
+	for peerIndex, peer := range *server.peers {
+		if peer.sender == nil {
+			continue
+		}
+
+		all = append(all, peerMetrics{
+			peerIndex: peerIndex,
+			depth:     peer.sender.depth(),
+			sent:      peer.sender.sent.Load(),
+			dropped:   peer.sender.dropped.Load(),
+		})
+	}
+	server.mutex.Unlock()
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(writer, "# HELP meetupstation_peer_queue_depth Local packets waiting to be sent to the peer.\n")
+	fmt.Fprintf(writer, "# TYPE meetupstation_peer_queue_depth gauge\n")
+	for _, peer := range all {
+		fmt.Fprintf(writer, "meetupstation_peer_queue_depth{peer=\"%d\"} %d\n", peer.peerIndex, peer.depth)
+	}
+
+	fmt.Fprintf(writer, "# HELP meetupstation_peer_packets_sent_total Local packets written to the tracks of the peer.\n")
+	fmt.Fprintf(writer, "# TYPE meetupstation_peer_packets_sent_total counter\n")
+	for _, peer := range all {
+		fmt.Fprintf(writer, "meetupstation_peer_packets_sent_total{peer=\"%d\"} %d\n", peer.peerIndex, peer.sent)
+	}
+
+	fmt.Fprintf(writer, "# HELP meetupstation_peer_packets_dropped_total Local packets dropped because the queue of the peer was full.\n")
+	fmt.Fprintf(writer, "# TYPE meetupstation_peer_packets_dropped_total counter\n")
+	for _, peer := range all {
+		fmt.Fprintf(writer, "meetupstation_peer_packets_dropped_total{peer=\"%d\"} %d\n", peer.peerIndex, peer.dropped)
+	}
+}
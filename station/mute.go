@@ -0,0 +1,65 @@
+package station
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+// Whether a local track is held back from all the peers, shared by the
+// sources of the track, like the layers of the video.
+type trackMute struct {
+	muted atomic.Bool
+}
+
+// Holds back the packets of one source while its track is muted. The
+// peers get the stream on without a gap in the sequence numbers, so that
+// they do not ask for the packets they missed, and a video goes on from a
+// keyframe.
+type muteGate struct {
+	mute      *trackMute
+	mediaType media.Type
+	mimeType  string
+	rewriter  *media.RTPRewriter
+	// muted since the last packet that went through
+	held bool
+}
+
+func newMuteGate(mute *trackMute, mediaType media.Type, codec webrtc.RTPCodecCapability) *muteGate {
+	gate := &muteGate{
+		mute:      mute,
+		mediaType: mediaType,
+		mimeType:  codec.MimeType,
+		rewriter:  media.NewRTPRewriter(0, codec.ClockRate),
+	}
+	gate.rewriter.SwitchTo(gate)
+
+	return gate
+}
+
+// Whether the packet goes to the peers, it is rewritten in place to
+// follow the ones before the mute.
+func (gate *muteGate) pass(packet *rtp.Packet) bool {
+	if gate.mute == nil {
+		return true
+	}
+
+	if gate.mute.muted.Load() {
+		gate.held = true
+		return false
+	}
+
+	if gate.held {
+		if gate.mediaType == media.Video && !isKeyframeStart(gate.mimeType, packet.Payload) {
+			return false
+		}
+
+		gate.held = false
+		gate.rewriter.SwitchTo(gate)
+	}
+
+	return gate.rewriter.Rewrite(gate, packet, packet)
+}
@@ -0,0 +1,183 @@
+package station
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+const (
+	// a guest that joins goes on air right away
+	OnAirNewest = "newest"
+	// a guest that joins goes on air only when nobody is
+	OnAirFirst = "first"
+)
+
+// Decides which guest is on air, the one whose remote media goes to the
+// sinks. The sinks are opened for the guest the first time it goes on air
+// and closed with the guest, so that its recordings go on when it is back.
+// The guests off air stay connected and the packets of their tracks are
+// dropped.
+//
+// The guests are the peers with remote media, the ones signalled and the
+// WHIP publishers, not the WHEP viewers.
+type onAirSelector struct {
+	peers   *[]Peer
+	mutex   *sync.Mutex
+	options *PeerOptions
+	policy  string
+
+	// guarded by mutex, like the peers
+	peer   int
+	guests []int
+	// changes every time the peer on air does, the tracks look at it
+	// before every packet and take their sinks again when it changed
+	generation atomic.Uint64
+}
+
+func newOnAirSelector(peers *[]Peer, mutex *sync.Mutex, options *PeerOptions, policy string) *onAirSelector {
+	return &onAirSelector{
+		peers:   peers,
+		mutex:   mutex,
+		options: options,
+		policy:  policy,
+		peer:    -1,
+	}
+}
+
+// A guest that is connected, the caller holds the mutex of the peers.
+func (selector *onAirSelector) join(peerIndex int) {
+	selector.guests = append(selector.guests, peerIndex)
+
+	if selector.policy == OnAirNewest || selector.peer == -1 {
+		selector.switchTo(peerIndex)
+	}
+}
+
+// A guest that is closed, the newest of the others goes on air in its
+// place. The caller holds the mutex of the peers.
+func (selector *onAirSelector) remove(peerIndex int) {
+	index := slices.Index(selector.guests, peerIndex)
+	if index == -1 {
+		return
+	}
+	selector.guests = slices.Delete(selector.guests, index, index+1)
+
+	if selector.peer != peerIndex {
+		return
+	}
+
+	next := -1
+	if len(selector.guests) != 0 {
+		next = selector.guests[len(selector.guests)-1]
+	}
+	selector.switchTo(next)
+}
+
+// The guest on air, -1 when there is none. The caller holds the mutex of
+// the peers.
+func (selector *onAirSelector) current() int {
+	return selector.peer
+}
+
+// Put a guest on air, -1 takes everybody off. The caller holds the mutex
+// of the peers.
+func (selector *onAirSelector) putOnAir(peerIndex int) error {
+	if peerIndex != -1 &&
+		(!slices.Contains(selector.guests, peerIndex) || (*selector.peers)[peerIndex].peerConnection == nil) {
+		return errors.New("not a connected guest")
+	}
+
+	selector.switchTo(peerIndex)
+
+	return nil
+}
+
+func (selector *onAirSelector) switchTo(peerIndex int) {
+	if peerIndex == selector.peer {
+		return
+	}
+
+	selector.peer = peerIndex
+
+	// the map is there once the sinks are open
+	if peerIndex != -1 && (*selector.peers)[peerIndex].remoteExtraVideoSinks == nil {
+		peer := &(*selector.peers)[peerIndex]
+		options := selector.options
+
+		peer.remoteAudioSinks = openSinks(peerIndex, media.Audio, options.AudioSinks, options)
+		peer.remoteVideoSinks = openSinks(peerIndex, media.Video, options.VideoSinks, options)
+		peer.remoteExtraVideoSinks = map[string][]media.Sink{}
+		for name, specs := range options.ExtraVideoSinks {
+			peer.remoteExtraVideoSinks[name] = openSinks(peerIndex, media.Video, specs, options)
+		}
+	}
+
+	if peerIndex == -1 {
+		fmt.Fprintf(os.Stderr, "on air: nobody\n")
+	} else {
+		fmt.Fprintf(os.Stderr, "conn %d: on air\n", peerIndex)
+	}
+	selector.options.emit(Event{Type: EventOnAirChanged, Peer: peerIndex})
+
+	selector.generation.Add(1)
+}
+
+// The sinks of a track of the peer, by the name the track router gave it,
+// nil while the peer is off air.
+func (selector *onAirSelector) sinks(peerIndex int, name string) []media.Sink {
+	selector.mutex.Lock()
+	defer selector.mutex.Unlock()
+
+	if peerIndex != selector.peer {
+		return nil
+	}
+
+	peer := &(*selector.peers)[peerIndex]
+	switch name {
+	case "video":
+		return peer.remoteVideoSinks
+	case "audio":
+		return peer.remoteAudioSinks
+	default:
+		return peer.remoteExtraVideoSinks[name]
+	}
+}
+
+// A guest asking to go on air over the data channel, with
+//
+//	{"type": "on-air"}
+//
+// false when the message is something else.
+func (selector *onAirSelector) handleMessage(peerIndex int, message webrtc.DataChannelMessage) bool {
+	var request struct {
+		Type string `json:"type"`
+	}
+	if !message.IsString ||
+		json.Unmarshal(message.Data, &request) != nil ||
+		request.Type != "on-air" {
+		return false
+	}
+
+	if !selector.options.OnAirRequests {
+		fmt.Fprintf(os.Stderr, "conn %d: on air request ignored, the station does not take them\n", peerIndex)
+		return true
+	}
+
+	selector.mutex.Lock()
+	defer selector.mutex.Unlock()
+
+	if err := selector.putOnAir(peerIndex); err != nil {
+		fmt.Fprintf(os.Stderr, "conn %d: on air request - %s\n", peerIndex, err)
+	}
+
+	return true
+}
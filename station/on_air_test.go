@@ -0,0 +1,118 @@
+package station
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+// Write packets of the guest on air to its audio sinks, like its track does.
+func writeOnAirAudio(t *testing.T, selector *onAirSelector, peerIndex int, packets int, sequence *uint16) {
+	t.Helper()
+
+	codec := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		PayloadType:        111,
+	}
+
+	sinks := selector.sinks(peerIndex, "audio")
+	if len(sinks) == 0 {
+		t.Fatalf("conn %d has no audio sinks on air", peerIndex)
+	}
+
+	for _, sink := range sinks {
+		if err := sink.Bind(codec); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < packets; i++ {
+			*sequence++
+			err := sink.WriteRTP(&rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					PayloadType:    111,
+					SequenceNumber: *sequence,
+					Timestamp:      uint32(*sequence) * 960,
+					SSRC:           1,
+				},
+				Payload: []byte{0xf8, 0xff, 0xfe},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestOnAirKeepsRecordingAcrossSwitches(t *testing.T) {
+	directory := t.TempDir()
+
+	var mutex sync.Mutex
+	peers := make([]Peer, 2)
+	options := &PeerOptions{
+		AudioSinks: []string{"file:" + filepath.Join(directory, "guest-{peer}.ogg")},
+	}
+	selector := newOnAirSelector(&peers, &mutex, options, OnAirNewest)
+	options.onAir = selector
+
+	var sequence uint16
+
+	// A, then B, then A again
+	mutex.Lock()
+	selector.join(0)
+	mutex.Unlock()
+	writeOnAirAudio(t, selector, 0, 5, &sequence)
+
+	mutex.Lock()
+	selector.join(1)
+	mutex.Unlock()
+	if sinks := selector.sinks(0, "audio"); sinks != nil {
+		t.Fatalf("conn 0 off air still has sinks %v", sinks)
+	}
+	writeOnAirAudio(t, selector, 1, 5, &sequence)
+
+	mutex.Lock()
+	selector.switchTo(0)
+	mutex.Unlock()
+	writeOnAirAudio(t, selector, 0, 3, &sequence)
+
+	mutex.Lock()
+	for peerIndex := range peers {
+		peers[peerIndex].Close(peerIndex)
+	}
+	mutex.Unlock()
+
+	file, err := os.Open(filepath.Join(directory, "guest-0.ogg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, _, err := oggreader.NewWith(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := 0
+	for {
+		page, _, err := reader.ParseNextPage()
+		if err != nil {
+			break
+		}
+		if strings.HasPrefix(string(page), "OpusTags") {
+			continue
+		}
+		pages++
+	}
+
+	// both segments of A
+	if pages != 5+3 {
+		t.Errorf("%d audio pages in the recording of conn 0, want %d", pages, 5+3)
+	}
+}
@@ -0,0 +1,128 @@
+package station
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+type Peer struct {
+	peerConnection   *webrtc.PeerConnection
+	localVideoTrack  *webrtc.TrackLocalStaticRTP
+	localAudioTrack  *webrtc.TrackLocalStaticRTP
+	dataChannel      *webrtc.DataChannel
+	remoteVideoSinks []media.Sink
+	remoteAudioSinks []media.Sink
+
+	// the videos besides the camera, indexed like PeerOptions.ExtraVideos
+	localExtraVideoTracks []*webrtc.TrackLocalStaticRTP
+	// the senders of the videos added while the station runs, by name
+	addedVideoSenders map[string]*webrtc.RTPSender
+	// the remote videos with sinks of their own, by track id
+	remoteExtraVideoSinks map[string][]media.Sink
+	// which layer of the local video the peer gets
+	videoLayers *layerSelector
+	// writes the local media to the tracks
+	sender *peerSender
+	// the bitrate the peer can take, from its congestion control feedback
+	bandwidthEstimator cc.BandwidthEstimator
+	// a peer with remote media, which can go on air
+	guest bool
+	// the ICE connection came up and peer-joined was published
+	joined bool
+	// run once when the peer is closed, for whatever reason, under the
+	// mutex of the peers
+	onClose []func()
+}
+
+// The ICE connection of the peer came up, the caller holds the mutex of
+// the peers.
+func (peer *Peer) connected(index int, options *PeerOptions) {
+	// closed before the callback got here
+	if peer.peerConnection == nil {
+		return
+	}
+
+	peer.joined = true
+	options.emit(Event{Type: EventPeerJoined, Peer: index})
+
+	if peer.guest {
+		options.onAir.join(index)
+	}
+}
+
+func (peer *Peer) Close(index int) {
+	if peer.peerConnection != nil {
+		for _, onClose := range peer.onClose {
+			onClose()
+		}
+		peer.onClose = nil
+
+		err := peer.peerConnection.Close()
+		peer.peerConnection = nil
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: peerConnection.Close - %s\n",
+				index,
+				err)
+		}
+	}
+
+	if peer.sender != nil {
+		peer.sender.stop()
+		peer.sender = nil
+	}
+
+	if peer.localVideoTrack != nil {
+		peer.localVideoTrack = nil
+	}
+
+	if peer.localAudioTrack != nil {
+		peer.localAudioTrack = nil
+	}
+
+	if peer.dataChannel != nil {
+		peer.dataChannel.OnMessage(func(message webrtc.DataChannelMessage) {
+		})
+
+		err := peer.dataChannel.Close()
+		peer.dataChannel = nil
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: dataChannel.Close - %s\n",
+				index,
+				err)
+		}
+	}
+
+	closeSinks(index, "remoteAudioSinks", peer.remoteAudioSinks)
+	peer.remoteAudioSinks = nil
+
+	closeSinks(index, "remoteVideoSinks", peer.remoteVideoSinks)
+	peer.remoteVideoSinks = nil
+
+	for name, sinks := range peer.remoteExtraVideoSinks {
+		closeSinks(index, "remoteExtraVideoSinks "+name, sinks)
+	}
+	peer.remoteExtraVideoSinks = nil
+}
+
+func closeSinks(index int, name string, sinks []media.Sink) {
+	for _, sink := range sinks {
+		err := sink.Close()
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: %s.Close - %s\n",
+				index,
+				name,
+				err)
+		}
+	}
+}
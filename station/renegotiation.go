@@ -0,0 +1,363 @@
+package station
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// A message of the data channel about the session: an offer or an answer
+// with its SDP, "negotiate", the request of a guest for an offer with the
+// kinds of its new tracks, or "turn", a guest asking for its turn to offer
+// and the host giving it.
+type renegotiationMessage struct {
+	Type  string   `json:"type"`
+	SDP   string   `json:"sdp,omitempty"`
+	Kinds []string `json:"kinds,omitempty"`
+}
+
+// Offers and answers again over the data channel when the tracks of a
+// connected peer change, like a screen share that starts or a guest that
+// adds a camera, instead of a new peer connection. The signalling server
+// only sees the first exchange.
+//
+// The roles are those of perfect negotiation: both sides offer when their
+// tracks change, the guest once the host gave it the turn. The host holds
+// its own offers from then until it has answered the one of the guest, so
+// that the offers do not cross. When they cross anyway, the impolite host
+// ignores the one of the guest, and the polite guest rolls its own back
+// and answers the host. The host still takes the "negotiate" requests of
+// the guests that ask for an offer instead.
+type renegotiator struct {
+	peerIndex      int
+	peerConnection *webrtc.PeerConnection
+	dataChannel    *webrtc.DataChannel
+	polite         bool
+	// the kinds the host takes from a guest
+	kinds []webrtc.RTPCodecType
+
+	mutex sync.Mutex
+	// negotiate when the data channel opens, the answer comes or the turn
+	// of the guest is over
+	pending bool
+	// the guest asked for its turn, and the host gave it
+	asked   bool
+	granted bool
+}
+
+func newRenegotiator(peerIndex int,
+	peerConnection *webrtc.PeerConnection,
+	dataChannel *webrtc.DataChannel,
+	options *PeerOptions) *renegotiator {
+
+	renegotiator := &renegotiator{
+		peerIndex:      peerIndex,
+		peerConnection: peerConnection,
+		dataChannel:    dataChannel,
+		polite:         options.Polite,
+	}
+	if options.Direction != webrtc.RTPTransceiverDirectionSendonly {
+		renegotiator.kinds = options.kinds()
+	}
+
+	// pion fires the event from its operations queue, which the offer
+	// needs itself
+	peerConnection.OnNegotiationNeeded(func() {
+		go renegotiator.negotiate()
+	})
+	dataChannel.OnOpen(func() {
+		renegotiator.mutex.Lock()
+		pending := renegotiator.pending
+		renegotiator.pending = false
+		renegotiator.mutex.Unlock()
+
+		if pending {
+			renegotiator.negotiate()
+		}
+	})
+
+	return renegotiator
+}
+
+func (renegotiator *renegotiator) negotiate() {
+	renegotiator.mutex.Lock()
+	defer renegotiator.mutex.Unlock()
+
+	// the first exchange goes through the signalling server and takes the
+	// tracks there are by then
+	if renegotiator.peerConnection.RemoteDescription() == nil {
+		return
+	}
+	if renegotiator.dataChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		renegotiator.pending = true
+		return
+	}
+
+	// pion asks again when the answer comes, if it still needs to
+	if renegotiator.peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		return
+	}
+
+	renegotiator.start()
+}
+
+// Offer, or for the guest ask for its turn to. The mutex is held and the
+// session is stable.
+func (renegotiator *renegotiator) start() {
+	if renegotiator.polite {
+		if !renegotiator.asked {
+			renegotiator.asked = true
+			renegotiator.send(renegotiationMessage{Type: "turn"})
+		}
+		return
+	}
+
+	// the guest has the turn
+	if renegotiator.granted {
+		renegotiator.pending = true
+		return
+	}
+
+	renegotiator.offer()
+}
+
+// What waited for the session to be stable again. The mutex is held.
+func (renegotiator *renegotiator) next() {
+	switch {
+	case !renegotiator.polite && renegotiator.asked:
+		renegotiator.asked = false
+		renegotiator.granted = true
+		renegotiator.send(renegotiationMessage{Type: "turn"})
+	case renegotiator.pending:
+		renegotiator.pending = false
+		renegotiator.start()
+	}
+}
+
+// The mutex is held.
+func (renegotiator *renegotiator) offer() {
+	fmt.Fprintf(os.Stderr, "conn %d: renegotiating\n", renegotiator.peerIndex)
+
+	offer, err := renegotiator.peerConnection.CreateOffer(nil)
+	if err == nil {
+		err = renegotiator.peerConnection.SetLocalDescription(offer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation offer - %s\n",
+			renegotiator.peerIndex,
+			err)
+		return
+	}
+
+	description := renegotiator.peerConnection.LocalDescription()
+	renegotiator.send(renegotiationMessage{Type: description.Type.String(), SDP: description.SDP})
+}
+
+// Take a message of the data channel, false when it is not about the
+// session.
+func (renegotiator *renegotiator) handleMessage(message webrtc.DataChannelMessage) bool {
+	if !message.IsString {
+		return false
+	}
+
+	var decoded renegotiationMessage
+	if err := json.Unmarshal(message.Data, &decoded); err != nil {
+		return false
+	}
+
+	renegotiator.mutex.Lock()
+	defer renegotiator.mutex.Unlock()
+
+	switch {
+	case decoded.Type == "negotiate" && !renegotiator.polite:
+		renegotiator.handleRequest(decoded.Kinds)
+	case decoded.Type == "negotiate":
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation - ignoring negotiate, only the host takes requests\n",
+			renegotiator.peerIndex)
+	case decoded.Type == "turn":
+		renegotiator.handleTurn()
+	case decoded.Type == "offer":
+		renegotiator.handleOffer(decoded.SDP)
+	case decoded.Type == "answer":
+		renegotiator.handleAnswer(decoded.SDP)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// The mutex is held.
+func (renegotiator *renegotiator) handleRequest(kinds []string) {
+	added := 0
+	for _, kind := range kinds {
+		codecType := webrtc.NewRTPCodecType(kind)
+		if !renegotiator.takes(codecType) {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: renegotiation - the station takes no %s from the guest\n",
+				renegotiator.peerIndex,
+				kind)
+			continue
+		}
+
+		_, err := renegotiator.peerConnection.AddTransceiverFromKind(codecType, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: renegotiation %s transceiver - %s\n",
+				renegotiator.peerIndex,
+				kind,
+				err)
+			continue
+		}
+		added++
+	}
+
+	// nothing the offer could carry, the guest would only ask again
+	if len(kinds) != 0 && added == 0 {
+		return
+	}
+
+	if renegotiator.peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		renegotiator.pending = true
+		return
+	}
+
+	renegotiator.start()
+}
+
+// The mutex is held.
+func (renegotiator *renegotiator) handleTurn() {
+	stable := renegotiator.peerConnection.SignalingState() == webrtc.SignalingStateStable
+
+	if !renegotiator.polite {
+		if stable && !renegotiator.granted {
+			renegotiator.granted = true
+			renegotiator.send(renegotiationMessage{Type: "turn"})
+			return
+		}
+
+		// given when our offer is answered or the guest is done
+		renegotiator.asked = true
+		return
+	}
+
+	renegotiator.asked = false
+
+	// the host waits for an offer to end the turn, even one without changes
+	if !stable {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation - our turn, but the session is not stable\n",
+			renegotiator.peerIndex)
+		return
+	}
+
+	renegotiator.offer()
+}
+
+func (renegotiator *renegotiator) takes(kind webrtc.RTPCodecType) bool {
+	for _, taken := range renegotiator.kinds {
+		if taken == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The mutex is held.
+func (renegotiator *renegotiator) handleOffer(sdp string) {
+	peerConnection := renegotiator.peerConnection
+
+	// the offers crossed
+	if peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		if !renegotiator.polite {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: renegotiation - ignoring the offer of the guest, ours is out\n",
+				renegotiator.peerIndex)
+			return
+		}
+
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation - rolling back our offer for the one of the host\n",
+			renegotiator.peerIndex)
+
+		// pion wants the SDP of the rolled back offer, not an empty one
+		err := peerConnection.SetLocalDescription(webrtc.SessionDescription{
+			Type: webrtc.SDPTypeRollback,
+			SDP:  peerConnection.LocalDescription().SDP,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: renegotiation rollback - %s\n",
+				renegotiator.peerIndex,
+				err)
+			return
+		}
+	}
+
+	err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp})
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation offer - %s\n",
+			renegotiator.peerIndex,
+			err)
+		return
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err == nil {
+		err = peerConnection.SetLocalDescription(answer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation answer - %s\n",
+			renegotiator.peerIndex,
+			err)
+		return
+	}
+
+	description := peerConnection.LocalDescription()
+	renegotiator.send(renegotiationMessage{Type: description.Type.String(), SDP: description.SDP})
+
+	// the turn of the guest is over
+	renegotiator.granted = false
+	renegotiator.next()
+}
+
+// The mutex is held.
+func (renegotiator *renegotiator) handleAnswer(sdp string) {
+	err := renegotiator.peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: sdp})
+	if err != nil {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation answer - %s\n",
+			renegotiator.peerIndex,
+			err)
+		return
+	}
+
+	// a request of the guest while the offer was out
+	renegotiator.next()
+}
+
+// The mutex is held.
+func (renegotiator *renegotiator) send(message renegotiationMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		panic(fmt.Sprintf("logic: json.Marshal of a renegotiation message - %s", err))
+	}
+
+	if err = renegotiator.dataChannel.SendText(string(data)); err != nil {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: renegotiation %s - %s\n",
+			renegotiator.peerIndex,
+			message.Type,
+			err)
+	}
+}
@@ -0,0 +1,116 @@
+package station
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// A remote video with sinks of its own, picked by a field of the track.
+type trackRoute struct {
+	// the key of PeerOptions.ExtraVideoSinks
+	name string
+	// id, stream or rid
+	field string
+	value string
+}
+
+// Parse the name of -extra-video-sink: id:value, stream:value or
+// rid:value, a plain name is a track id.
+func parseTrackRoute(name string) trackRoute {
+	field, value, found := strings.Cut(name, ":")
+	if !found || (field != "id" && field != "stream" && field != "rid") {
+		return trackRoute{name: name, field: "id", value: name}
+	}
+
+	return trackRoute{name: name, field: field, value: value}
+}
+
+func (route trackRoute) matches(track *webrtc.TrackRemote) bool {
+	switch route.field {
+	case "stream":
+		return track.StreamID() == route.value
+	case "rid":
+		return track.RID() == route.value
+	default:
+		return track.ID() == route.value
+	}
+}
+
+// Picks the sinks of the remote tracks of a peer. Every group of sinks
+// takes one track at a time, the packets of two tracks in the same sinks
+// would mix their SSRCs and sequence numbers.
+type trackRouter struct {
+	peerIndex int
+	routes    []trackRoute
+
+	mutex sync.Mutex
+	taken map[string]bool
+}
+
+func newTrackRouter(peerIndex int, extraVideoSinks map[string][]string) *trackRouter {
+	router := &trackRouter{peerIndex: peerIndex, taken: map[string]bool{}}
+	for _, name := range extraVideoSinkNames(extraVideoSinks) {
+		router.routes = append(router.routes, parseTrackRoute(name))
+	}
+
+	return router
+}
+
+// The sinks for track: the name of an extra video, "audio" or "video" for
+// the main sinks of its kind, "" when the sinks for it are all taken.
+func (router *trackRouter) take(track *webrtc.TrackRemote) string {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+
+	name := ""
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		for _, route := range router.routes {
+			if !router.taken[route.name] && route.matches(track) {
+				name = route.name
+				break
+			}
+		}
+	}
+	if name == "" && !router.taken[track.Kind().String()] {
+		name = track.Kind().String()
+	}
+
+	if name == "" {
+		fmt.Fprintf(os.Stderr,
+			"conn %d: %s has no free sinks, dropping it\n",
+			router.peerIndex,
+			trackLabel(track))
+		return ""
+	}
+
+	router.taken[name] = true
+	fmt.Fprintf(os.Stderr,
+		"conn %d: %s to the %s sinks\n",
+		router.peerIndex,
+		trackLabel(track),
+		name)
+
+	return name
+}
+
+// Free the sinks of a track that ended.
+func (router *trackRouter) release(name string) {
+	router.mutex.Lock()
+	defer router.mutex.Unlock()
+
+	delete(router.taken, name)
+}
+
+// The track as the logs and the egress SDP show it.
+func trackLabel(track *webrtc.TrackRemote) string {
+	label := fmt.Sprintf("%s track id=%s stream=%s", track.Kind(), track.ID(), track.StreamID())
+	if track.RID() != "" {
+		label += " rid=" + track.RID()
+	}
+
+	return label
+}
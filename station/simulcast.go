@@ -0,0 +1,262 @@
+package station
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+// One encoding of the local video, like the 720p and the 360p output of
+// the same encoder. Browsers can not receive simulcast, so every peer gets
+// a single layer on its video track, picked from its bandwidth estimate.
+type videoLayer struct {
+	rid string
+	// measured over the last second, in bits per second
+	bitrate atomic.Int64
+
+	// only used by the goroutine streaming the layer
+	windowStart time.Time
+	windowBytes int
+}
+
+func newVideoLayer(rid string) *videoLayer {
+	return &videoLayer{rid: rid}
+}
+
+func (layer *videoLayer) measure(packet *rtp.Packet) {
+	now := time.Now()
+	if layer.windowStart.IsZero() {
+		layer.windowStart = now
+	}
+
+	layer.windowBytes += packet.MarshalSize()
+
+	elapsed := now.Sub(layer.windowStart)
+	if elapsed >= time.Second {
+		layer.bitrate.Store(int64(layer.windowBytes) * 8 * int64(time.Second) / int64(elapsed))
+		layer.windowStart = now
+		layer.windowBytes = 0
+	}
+}
+
+// Parse "rid=spec" of -video-layer.
+func parseVideoLayer(value string) (string, string, error) {
+	rid, spec, found := strings.Cut(value, "=")
+	if !found || rid == "" || spec == "" {
+		return "", "", fmt.Errorf("expecting rid=source, got %q", value)
+	}
+
+	return rid, spec, nil
+}
+
+const (
+	// the estimate has to exceed the bitrate of a better layer by this much
+	// before the peer moves up, so that it does not flap between layers
+	layerUpHeadroom = 1.3
+	// the send side estimate has to stay high this long before the peer
+	// tries the next better layer, and after a move down even longer
+	layerProbeInterval = 5 * time.Second
+	layerProbeBackoff  = 30 * time.Second
+)
+
+// Picks the layer a peer gets and rewrites the packets of the layers into
+// one continuous stream, the sequence numbers and timestamps of the
+// encodings have nothing in common.
+type layerSelector struct {
+	peerIndex int
+	layers    []*videoLayer
+	mimeType  string
+	rewriter  *media.RTPRewriter
+
+	mutex sync.Mutex
+	// layer sent to the peer and the one it moves to at the next keyframe
+	current int
+	pending int
+
+	// for the moves up on the send side estimate
+	highSince time.Time
+	lastDown  time.Time
+}
+
+func newLayerSelector(peerIndex int, layers []*videoLayer, codec webrtc.RTPCodecCapability) *layerSelector {
+	rewriter := media.NewRTPRewriter(0, codec.ClockRate)
+	rewriter.SwitchTo(0)
+
+	return &layerSelector{
+		peerIndex: peerIndex,
+		layers:    layers,
+		mimeType:  codec.MimeType,
+		rewriter:  rewriter,
+	}
+}
+
+// Called with a bandwidth estimate of the receiver, like REMB, in bits
+// per second.
+func (selector *layerSelector) setEstimate(estimate uint64) {
+	if len(selector.layers) < 2 {
+		return
+	}
+
+	selector.mutex.Lock()
+	defer selector.mutex.Unlock()
+
+	selector.moveTo(selector.bestLayer(estimate), estimate)
+}
+
+// Called with the estimate of the congestion controller, in bits per
+// second. It never gets far above the bitrate the peer gets, so a good
+// estimate only lets the peer try the next better layer, and the estimate
+// drops again when that layer is too much.
+func (selector *layerSelector) setSendSideEstimate(estimate uint64) {
+	if len(selector.layers) < 2 {
+		return
+	}
+
+	selector.mutex.Lock()
+	defer selector.mutex.Unlock()
+
+	now := time.Now()
+
+	target := selector.bestLayer(estimate)
+	if target > selector.pending {
+		selector.highSince = time.Time{}
+		selector.lastDown = now
+		selector.moveTo(target, estimate)
+		return
+	}
+
+	bitrate := float64(selector.layers[selector.pending].bitrate.Load())
+	if selector.pending == 0 || float64(estimate) < bitrate*layerUpHeadroom {
+		selector.highSince = time.Time{}
+		return
+	}
+
+	if selector.highSince.IsZero() {
+		selector.highSince = now
+	}
+
+	if now.Sub(selector.highSince) >= layerProbeInterval &&
+		now.Sub(selector.lastDown) >= layerProbeBackoff {
+		selector.highSince = time.Time{}
+		selector.moveTo(selector.pending-1, estimate)
+	}
+}
+
+// The best layer that fits into the estimate, the mutex is held.
+func (selector *layerSelector) bestLayer(estimate uint64) int {
+	// the layers are best first, the last one is the fallback
+	for index, layer := range selector.layers {
+		bitrate := float64(layer.bitrate.Load())
+		if index < selector.pending {
+			bitrate *= layerUpHeadroom
+		}
+
+		if bitrate <= float64(estimate) {
+			return index
+		}
+	}
+
+	return len(selector.layers) - 1
+}
+
+func (selector *layerSelector) moveTo(target int, estimate uint64) {
+	if target == selector.pending {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr,
+		"conn %d: estimate %d kbit/s, moving to video layer %s\n",
+		selector.peerIndex,
+		estimate/1000,
+		selector.layers[target].rid)
+	selector.pending = target
+}
+
+// Copy the packet as the peer gets it into outbound, false when the peer
+// does not get the layer. The packet of the layer is not changed, it is
+// shared by all the peers.
+func (selector *layerSelector) forward(layer int, packet *rtp.Packet, outbound *rtp.Packet) bool {
+	selector.mutex.Lock()
+	defer selector.mutex.Unlock()
+
+	if layer == selector.pending &&
+		layer != selector.current &&
+		isKeyframeStart(selector.mimeType, packet.Payload) {
+		selector.current = layer
+		selector.rewriter.SwitchTo(layer)
+	}
+
+	if layer != selector.current {
+		return false
+	}
+
+	return selector.rewriter.Rewrite(layer, packet, outbound)
+}
+
+// Read the RTCP of a sender for the REMB of the peer. Reading also runs
+// the interceptors, like the NACK responder and the congestion controller.
+func readFeedback(sender *webrtc.RTPSender, selector *layerSelector) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			if remb, ok := packet.(*rtcp.ReceiverEstimatedMaximumBitrate); ok {
+				selector.setEstimate(uint64(remb.Bitrate))
+			}
+		}
+	}
+}
+
+// Whether the payload starts a keyframe, the only place a peer can move to
+// another layer.
+func isKeyframeStart(mimeType string, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeH264):
+		naluType := payload[0] & 0x1f
+		switch naluType {
+		case 5, 7: // IDR slice, SPS
+			return true
+		case 24: // STAP-A, the first aggregated unit
+			return len(payload) > 3 && (payload[3]&0x1f == 5 || payload[3]&0x1f == 7)
+		case 28: // FU-A, the start fragment
+			return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1f == 5
+		}
+		return false
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		var vp8 codecs.VP8Packet
+		data, err := vp8.Unmarshal(payload)
+		if err != nil || len(data) == 0 {
+			return false
+		}
+		return vp8.S == 1 && vp8.PID == 0 && data[0]&0x01 == 0
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		var vp9 codecs.VP9Packet
+		if _, err := vp9.Unmarshal(payload); err != nil {
+			return false
+		}
+		return vp9.B && !vp9.P
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		// N: the first packet of a coded video sequence
+		return payload[0]&0x08 != 0
+	}
+
+	// nothing to look at, switch right away
+	return true
+}
@@ -0,0 +1,825 @@
+// Package station bridges the local media to WebRTC peers and the media of
+// the peers to local sinks. A Station meets a host or a guest through the
+// signalling server, serves WHIP and WHEP publishers and players over HTTP,
+// or both.
+package station
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+	"meetupstation/meetupstation-pion/signalling"
+)
+
+type PeerType int
+
+const (
+	PeerTypeHost  PeerType = 0
+	PeerTypeGuest PeerType = 1
+)
+
+// What a Station is made of, the command line flags of meetupstation-pion.
+type Options struct {
+	// the signalling server, like https://meetupstation.com, empty serves
+	// only the HTTP endpoints
+	SignalServer string
+	Signalling   signalling.Options
+	// the side of the room the station takes and the room
+	PeerType PeerType
+	HostID   string
+
+	// PEM file with the station DTLS certificate, generated when missing,
+	// empty uses a new certificate per connection
+	CertificatePath string
+	// accept only a remote with one of these DTLS fingerprints, like
+	// "sha-256 AB:CD:..."
+	PinnedFingerprints []string
+
+	// sendrecv, sendonly or recvonly
+	Direction webrtc.RTPTransceiverDirection
+	// the media of the sessions, at least one of them
+	Audio bool
+	Video bool
+
+	// local media, see media.NewSource
+	AudioSource string
+	VideoSource string
+	// encodings of the local video as rid=source, best first, replace
+	// VideoSource
+	VideoLayers []string
+	// other local videos as name=source
+	ExtraVideoSources []string
+	// frame rate of the file:path.h264 video sources
+	H264FrameRate int
+	// the ids the browsers see
+	StreamID     string
+	AudioTrackID string
+	VideoTrackID string
+	// bind the same local tracks to every peer, without VideoLayers
+	SharedTracks bool
+	// where the bitrate the peers can take goes, see reportTargetBitrate,
+	// empty does not report it
+	BitrateFeedback string
+
+	// remote media, see media.NewSink, empty is udp:4004 and udp:4006
+	AudioSinks []string
+	VideoSinks []string
+	// where the remote videos with a name of their own go, as name=sink
+	ExtraVideoSinks []string
+	// keep an SDP file of the udp sinks at this path, empty does not
+	EgressSDP string
+	// serve the remote media as rtsp://<address>/guest, empty does not
+	RTSPAddress string
+	// hold the remote media up to this long to reorder it, 0 does not
+	JitterBufferLatency time.Duration
+	// which guest goes on air when one joins, OnAirNewest or OnAirFirst,
+	// empty is OnAirNewest
+	OnAirPolicy string
+	// let a guest go on air by sending {"type": "on-air"} over the data
+	// channel
+	OnAirRequests bool
+
+	// serve /whip, /whep and /metrics on this address, empty does not
+	HTTPAddress string
+	// bearer tokens the WHIP publishers and WHEP players have to send,
+	// empty lets anybody in
+	WHIPToken string
+	WHEPToken string
+	// disconnect a peer that can not keep up with the local media for this
+	// long, 0 never does
+	SlowPeerTimeout time.Duration
+
+	// serve the control API on "unix:path" or "[host]:port", empty does
+	// not, see controlServer
+	ControlAddress string
+	// let the control API listen on an address other than a loopback one
+	ControlAllowRemote bool
+
+	// POST the events as JSON to this URL, empty does not
+	Webhook string
+	// sent as "Authorization: Bearer <token>" to the webhook when not empty
+	WebhookToken string
+
+	// called with every event in order, from a goroutine of its own so
+	// that it can call the Station, the events that come while it is
+	// behind by more than 256 are dropped, see Subscribe
+	OnEvent func(Event)
+}
+
+type Station struct {
+	options      Options
+	peerOptions  PeerOptions
+	signalClient *signalling.Client
+	events       *eventBus
+
+	// the local media, every one streams to a track
+	audioSource       media.Source
+	videoSources      []media.Source
+	extraVideoSources []media.Source
+	// the local tracks that can be muted, "audio", "video" and the names
+	// of the extra videos, guarded by mutex
+	mutes map[string]*trackMute
+	// the videos added while the station runs, by name
+	addedVideoStreams map[string]addedVideoStream
+
+	mutex sync.Mutex
+	peers []Peer
+	// the room of the next peer connections, the control API changes it
+	hostID string
+	// stops the signalling of the peer connection that waits for the
+	// server, so that it starts over in a new room
+	cancelSignal context.CancelFunc
+	// the context of Run, nil while the station does not run
+	runCtx context.Context
+}
+
+// Set up a station: the signalling client, the certificate, the local media
+// sources and the remote media sinks. Nothing is sent before Run.
+func New(options Options) (*Station, error) {
+	station := &Station{
+		options: options,
+		events:  newEventBus(options.OnEvent),
+		mutes:   map[string]*trackMute{},
+		hostID:  options.HostID,
+
+		addedVideoStreams: map[string]addedVideoStream{},
+	}
+	peerOptions := &station.peerOptions
+
+	if !options.Audio && !options.Video {
+		return nil, errors.New("no media, neither audio nor video")
+	}
+	if options.Direction == webrtc.RTPTransceiverDirectionUnknown ||
+		options.Direction == webrtc.RTPTransceiverDirectionInactive {
+		return nil, fmt.Errorf("direction %s", options.Direction)
+	}
+	if options.SignalServer == "" && options.HTTPAddress == "" {
+		return nil, errors.New("neither a signalling server nor an http address")
+	}
+	if options.OnAirPolicy == "" {
+		options.OnAirPolicy = OnAirNewest
+	}
+	if options.OnAirPolicy != OnAirNewest && options.OnAirPolicy != OnAirFirst {
+		return nil, fmt.Errorf("unknown on air policy %q", options.OnAirPolicy)
+	}
+
+	peerOptions.Direction = options.Direction
+	peerOptions.Audio = options.Audio
+	peerOptions.Video = options.Video
+	peerOptions.StreamID = options.StreamID
+	peerOptions.AudioTrackID = options.AudioTrackID
+	peerOptions.VideoTrackID = options.VideoTrackID
+	peerOptions.SlowPeerTimeout = options.SlowPeerTimeout
+	peerOptions.Polite = options.PeerType == PeerTypeGuest
+	peerOptions.JitterBufferLatency = options.JitterBufferLatency
+	peerOptions.events = station.events
+	peerOptions.OnAirRequests = options.OnAirRequests
+	peerOptions.onAir = newOnAirSelector(&station.peers, &station.mutex, peerOptions, options.OnAirPolicy)
+
+	sending := peerOptions.Direction != webrtc.RTPTransceiverDirectionRecvonly
+	receiving := peerOptions.Direction != webrtc.RTPTransceiverDirectionSendonly
+
+	var err error
+	if options.SignalServer != "" {
+		onError := options.Signalling.OnError
+		options.Signalling.OnError = func(peerIndex int, err error) {
+			if onError != nil {
+				onError(peerIndex, err)
+			}
+			station.events.publish(Event{
+				Type:  EventSignallingError,
+				Peer:  peerIndex,
+				Error: err.Error(),
+			})
+		}
+
+		station.signalClient, err = signalling.NewClient(options.SignalServer, options.Signalling)
+		if err != nil {
+			return nil, fmt.Errorf("signalling server: %w", err)
+		}
+	}
+
+	if options.CertificatePath != "" {
+		certificate, err := loadCertificate(options.CertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("certificate: %w", err)
+		}
+
+		fingerprints, err := certificateFingerprints(certificate)
+		if err != nil {
+			return nil, fmt.Errorf("certificate: %w", err)
+		}
+		for _, fingerprint := range fingerprints {
+			fmt.Fprintf(os.Stderr, "local fingerprint: %s\n", fingerprint)
+		}
+
+		peerOptions.Certificates = []webrtc.Certificate{*certificate}
+	}
+
+	// no listeners for the local media when there is nothing to send
+	if sending && peerOptions.Audio {
+		station.audioSource, err = media.NewSource(media.Audio, options.AudioSource, options.H264FrameRate)
+		if err != nil {
+			return nil, fmt.Errorf("audio source: %w", err)
+		}
+		peerOptions.AudioCodec = station.audioSource.Codec()
+	}
+
+	// a single layer without a rid unless there are VideoLayers
+	videoRIDs := []string{""}
+	videoSpecs := []string{options.VideoSource}
+	if len(options.VideoLayers) != 0 {
+		videoRIDs, videoSpecs = nil, nil
+	}
+	for _, value := range options.VideoLayers {
+		rid, spec, err := parseVideoLayer(value)
+		if err != nil {
+			station.closeSources()
+			return nil, fmt.Errorf("video layer: %w", err)
+		}
+		videoRIDs = append(videoRIDs, rid)
+		videoSpecs = append(videoSpecs, spec)
+	}
+	if !sending || !peerOptions.Video {
+		videoRIDs, videoSpecs = nil, nil
+	}
+
+	for layerIndex, spec := range videoSpecs {
+		rid := videoRIDs[layerIndex]
+
+		videoSource, err := media.NewSource(media.Video, spec, options.H264FrameRate)
+		if err != nil {
+			station.closeSources()
+			return nil, fmt.Errorf("video source: %w", err)
+		}
+		station.videoSources = append(station.videoSources, videoSource)
+
+		// the peers have a single video track for all the layers
+		if len(peerOptions.VideoLayers) != 0 &&
+			!strings.EqualFold(videoSource.Codec().MimeType, peerOptions.VideoCodec.MimeType) {
+			station.closeSources()
+			return nil, fmt.Errorf("video layer %s: %s, the other layers are %s",
+				rid, videoSource.Codec().MimeType, peerOptions.VideoCodec.MimeType)
+		}
+
+		peerOptions.VideoCodec = videoSource.Codec()
+		peerOptions.VideoLayers = append(peerOptions.VideoLayers, newVideoLayer(rid))
+	}
+
+	for _, value := range options.ExtraVideoSources {
+		name, spec, err := parseExtraVideo(value)
+		if err != nil {
+			station.closeSources()
+			return nil, fmt.Errorf("extra video source: %w", err)
+		}
+		if !sending || !peerOptions.Video {
+			continue
+		}
+
+		extraVideoSource, err := media.NewSource(media.Video, spec, options.H264FrameRate)
+		if err != nil {
+			station.closeSources()
+			return nil, fmt.Errorf("extra video source %s: %w", name, err)
+		}
+
+		station.extraVideoSources = append(station.extraVideoSources, extraVideoSource)
+		peerOptions.ExtraVideos = append(peerOptions.ExtraVideos,
+			newExtraVideo(name, extraVideoSource.Codec()))
+	}
+
+	if station.audioSource != nil {
+		station.mutes["audio"] = &trackMute{}
+	}
+	if len(station.videoSources) != 0 {
+		station.mutes["video"] = &trackMute{}
+	}
+	for _, extra := range peerOptions.ExtraVideos {
+		station.mutes[extra.name] = &trackMute{}
+	}
+
+	// the same SSRCs for every peer connection, a peer that reconnects
+	// gets the stream it had
+	peerOptions.AudioSSRC = webrtc.SSRC(media.RandomSSRC())
+	peerOptions.VideoSSRC = webrtc.SSRC(media.RandomSSRC())
+
+	if options.SharedTracks && sending {
+		if err = station.shareTracks(); err != nil {
+			station.closeSources()
+			return nil, err
+		}
+	}
+
+	peerOptions.SinkOptions.EgressStreams = media.NewEgressStreams()
+	// no sockets for the remote media when nothing comes in
+	if receiving {
+		if err = station.describeSinks(); err != nil {
+			station.closeSources()
+			return nil, err
+		}
+	} else if options.RTSPAddress != "" {
+		station.closeSources()
+		return nil, errors.New("rtsp server: a sendonly station has no remote media to serve")
+	}
+
+	if options.EgressSDP != "" {
+		peerOptions.SinkOptions.EgressDescription = media.NewEgressDescription(options.EgressSDP)
+
+		// the default codecs until the first guest, so that a player can
+		// be started right away
+		media.DescribeUDPSinks(peerOptions.SinkOptions.EgressDescription, media.Audio,
+			peerOptions.AudioSinks, media.DefaultEgressAudioCodec)
+		media.DescribeUDPSinks(peerOptions.SinkOptions.EgressDescription, media.Video,
+			peerOptions.VideoSinks, media.DefaultEgressVideoCodec)
+		for _, name := range extraVideoSinkNames(peerOptions.ExtraVideoSinks) {
+			media.DescribeUDPSinks(peerOptions.SinkOptions.EgressDescription, media.Video,
+				peerOptions.ExtraVideoSinks[name], media.DefaultEgressVideoCodec)
+		}
+	}
+
+	if options.RTSPAddress != "" {
+		peerOptions.SinkOptions.RTSPServer, err = media.NewRTSPServer(options.RTSPAddress)
+		if err != nil {
+			station.closeSources()
+			return nil, fmt.Errorf("rtsp server: %w", err)
+		}
+		if peerOptions.Audio {
+			peerOptions.AudioSinks = append(peerOptions.AudioSinks, "rtsp")
+		}
+		if peerOptions.Video {
+			peerOptions.VideoSinks = append(peerOptions.VideoSinks, "rtsp")
+		}
+
+		fmt.Fprintf(os.Stderr, "rtsp: serving rtsp://%s%s\n", options.RTSPAddress, media.RTSPGuestPath)
+	}
+
+	return station, nil
+}
+
+// Bind the same local tracks to every peer, a shared track sends the same
+// packets to all of them.
+func (station *Station) shareTracks() error {
+	peerOptions := &station.peerOptions
+
+	if len(peerOptions.VideoLayers) > 1 {
+		return errors.New("shared tracks can not pick a video layer per peer")
+	}
+
+	var err error
+	if peerOptions.Audio {
+		peerOptions.SharedAudioTrack, err = webrtc.NewTrackLocalStaticRTP(
+			peerOptions.AudioCodec, peerOptions.AudioTrackID, peerOptions.StreamID)
+		if err != nil {
+			return fmt.Errorf("shared audio track: %w", err)
+		}
+	}
+
+	if peerOptions.Video {
+		peerOptions.SharedVideoTrack, err = webrtc.NewTrackLocalStaticRTP(
+			peerOptions.VideoCodec, peerOptions.VideoTrackID, peerOptions.StreamID)
+		if err != nil {
+			return fmt.Errorf("shared video track: %w", err)
+		}
+
+		for _, extra := range peerOptions.ExtraVideos {
+			extra.shared, err = webrtc.NewTrackLocalStaticRTP(
+				extra.codec, extra.name, peerOptions.StreamID)
+			if err != nil {
+				return fmt.Errorf("shared %s track: %w", extra.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Where the remote media of the peers goes.
+func (station *Station) describeSinks() error {
+	peerOptions := &station.peerOptions
+
+	if peerOptions.Audio {
+		peerOptions.AudioSinks = station.options.AudioSinks
+		if len(peerOptions.AudioSinks) == 0 {
+			peerOptions.AudioSinks = []string{"udp:4004"}
+		}
+	}
+	if !peerOptions.Video {
+		return nil
+	}
+
+	peerOptions.VideoSinks = station.options.VideoSinks
+	if len(peerOptions.VideoSinks) == 0 {
+		peerOptions.VideoSinks = []string{"udp:4006"}
+	}
+
+	peerOptions.ExtraVideoSinks = map[string][]string{}
+	for _, value := range station.options.ExtraVideoSinks {
+		name, spec, err := parseExtraVideo(value)
+		if err != nil {
+			return fmt.Errorf("extra video sink: %w", err)
+		}
+		// the rtsp path has a single video track
+		if spec == "rtsp" {
+			return fmt.Errorf("extra video sink %s: rtsp serves only the main video", name)
+		}
+		peerOptions.ExtraVideoSinks[name] = append(peerOptions.ExtraVideoSinks[name], spec)
+	}
+
+	return nil
+}
+
+// For a station that does not run after all, Run closes them itself.
+func (station *Station) closeSources() {
+	sources := append([]media.Source{}, station.videoSources...)
+	sources = append(sources, station.extraVideoSources...)
+	if station.audioSource != nil {
+		sources = append(sources, station.audioSource)
+	}
+
+	for _, source := range sources {
+		if err := source.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "source.Close, %s\n", err)
+		}
+	}
+}
+
+// Queue the events of the station from now on for the caller, up to buffer
+// of them while the caller is busy, the later ones are dropped until it
+// catches up. Unsubscribing closes the channel.
+func (station *Station) Subscribe(buffer int) (<-chan Event, func()) {
+	return station.events.subscribe(buffer)
+}
+
+// Stream the local media to the peers, serve the HTTP endpoints and meet
+// the peers through the signalling server until ctx is done or the HTTP
+// server fails. The peers and the sources are closed on the way out.
+func (station *Station) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peerOptions := &station.peerOptions
+
+	if station.options.BitrateFeedback != "" {
+		err := reportTargetBitrate(ctx, &station.peers, &station.mutex, station.options.BitrateFeedback)
+		if err != nil {
+			station.closeSources()
+			return fmt.Errorf("bitrate feedback: %w", err)
+		}
+	}
+
+	if station.options.Webhook != "" {
+		events, unsubscribe := station.Subscribe(webhookQueueLength)
+		defer unsubscribe()
+
+		go newWebhook(station.options.Webhook, station.options.WebhookToken).run(ctx, events)
+	}
+
+	if station.audioSource != nil {
+		go streamLocalTrack(ctx, &station.peers, &station.mutex, media.Audio, nil, 0, -1,
+			peerOptions.SharedAudioTrack,
+			station.mutes["audio"],
+			station.audioSource)
+	}
+	for layerIndex, videoSource := range station.videoSources {
+		go streamLocalTrack(ctx, &station.peers, &station.mutex, media.Video,
+			peerOptions.VideoLayers[layerIndex], layerIndex, -1,
+			peerOptions.SharedVideoTrack,
+			station.mutes["video"],
+			videoSource)
+	}
+	for extraIndex, extraVideoSource := range station.extraVideoSources {
+		go streamLocalTrack(ctx, &station.peers, &station.mutex, media.Video,
+			nil, 0, extraIndex,
+			peerOptions.ExtraVideos[extraIndex].shared,
+			station.mutes[peerOptions.ExtraVideos[extraIndex].name],
+			extraVideoSource)
+	}
+
+	// videos can come and go from now on
+	station.mutex.Lock()
+	station.runCtx = ctx
+	station.mutex.Unlock()
+
+	serverErrors := make(chan error, 2)
+
+	var server *http.Server
+	if station.options.HTTPAddress != "" {
+		mux := http.NewServeMux()
+		newWHIPServer(&station.peers, &station.mutex, peerOptions, station.options.WHIPToken).register(mux)
+		newWHEPServer(&station.peers, &station.mutex, peerOptions, station.options.WHEPToken).register(mux)
+		newMetricsServer(&station.peers, &station.mutex).register(mux)
+
+		server = &http.Server{Addr: station.options.HTTPAddress, Handler: mux}
+		go func() {
+			err := server.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				serverErrors <- fmt.Errorf("http server: %w", err)
+			}
+		}()
+
+		fmt.Fprintf(os.Stderr, "http: serving whip on http://%s/whip and whep on http://%s/whep\n",
+			station.options.HTTPAddress, station.options.HTTPAddress)
+	}
+
+	var control *http.Server
+	if station.options.ControlAddress != "" {
+		listener, err := listenControl(station.options.ControlAddress, station.options.ControlAllowRemote)
+		if err != nil {
+			cancel()
+			if server != nil {
+				server.Close()
+			}
+			return fmt.Errorf("control api: %w", err)
+		}
+
+		mux := http.NewServeMux()
+		newControlServer(station).register(mux)
+
+		control = &http.Server{Handler: mux}
+		go func() {
+			err := control.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				serverErrors <- fmt.Errorf("control api: %w", err)
+			}
+		}()
+
+		fmt.Fprintf(os.Stderr, "control: serving on %s\n", station.options.ControlAddress)
+	}
+
+	if station.signalClient != nil {
+		go station.signal(ctx)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serverErrors:
+	}
+	cancel()
+
+	if server != nil {
+		server.Close()
+	}
+	if control != nil {
+		control.Close()
+	}
+	if rtspServer := peerOptions.SinkOptions.RTSPServer; rtspServer != nil {
+		rtspServer.Close()
+	}
+
+	station.mutex.Lock()
+	for peerIndex := range station.peers {
+		station.peers[peerIndex].Close(peerIndex)
+	}
+	station.runCtx = nil
+	station.mutex.Unlock()
+
+	return err
+}
+
+// Meet the peers through the signalling server one after the other, the
+// host offers a new peer connection as soon as the last one settled.
+func (station *Station) signal(ctx context.Context) {
+	peerOptions := &station.peerOptions
+	signalClient := station.signalClient
+	pinnedFingerprints := station.options.PinnedFingerprints
+	peerType := station.options.PeerType
+
+	for ctx.Err() == nil {
+		station.mutex.Lock()
+		hostId := station.hostID
+		// the one of the last peer connection, when it gave up
+		if station.cancelSignal != nil {
+			station.cancelSignal()
+		}
+		signalCtx, cancelSignal := context.WithCancel(ctx)
+		station.cancelSignal = cancelSignal
+		station.mutex.Unlock()
+
+		fmt.Fprintf(os.Stderr, "starting a new peer connection...\n")
+
+		peerIndex, connectedChannel := newPeerConnection(&station.peers, &station.mutex, peerOptions)
+
+		var localSessionDescription webrtc.SessionDescription
+
+		station.mutex.Lock()
+		fmt.Fprintf(os.Stderr, "conn %d: setting up tracks and data handlers\n", peerIndex)
+		setupTracksAndDataHandlers(&station.peers, peerIndex, peerOptions)
+		station.mutex.Unlock()
+
+		if peerType == PeerTypeHost {
+			for {
+				station.mutex.Lock()
+				offerSessionDescription, err := station.peers[peerIndex].peerConnection.CreateOffer(nil)
+				station.mutex.Unlock()
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "while creating offer: %s\n", err)
+					continue
+				}
+				localSessionDescription = offerSessionDescription
+				break
+			}
+		} else {
+			hostOffer, err := signalClient.WaitForHost(signalCtx, hostId, peerIndex)
+			if err != nil {
+				station.mutex.Lock()
+				station.peers[peerIndex].Close(peerIndex)
+				station.mutex.Unlock()
+				continue
+			}
+
+			if err := verifyFingerprints(&hostOffer, pinnedFingerprints); err != nil {
+				fmt.Fprintf(os.Stderr, "conn %d: refusing the host - %s\n", peerIndex, err)
+				station.mutex.Lock()
+				station.peers[peerIndex].Close(peerIndex)
+				station.mutex.Unlock()
+
+				select {
+				case <-signalCtx.Done():
+				case <-time.After(1 * time.Second):
+				}
+				continue
+			}
+
+			for {
+				station.mutex.Lock()
+				err := station.peers[peerIndex].peerConnection.SetRemoteDescription(hostOffer)
+				station.mutex.Unlock()
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr,
+						"while setting remote description: %s\n",
+						err)
+					continue
+				}
+				break
+			}
+
+			for {
+				station.mutex.Lock()
+				answerSessionDescription, err := station.peers[peerIndex].peerConnection.CreateAnswer(nil)
+				station.mutex.Unlock()
+
+				if err != nil {
+					fmt.Fprintf(os.Stderr,
+						"while creating answer: %s\n",
+						err)
+					continue
+				}
+				localSessionDescription = answerSessionDescription
+				break
+			}
+		}
+
+		// later will be locking untill this channel completes
+		station.mutex.Lock()
+		waitForAllICECandidates := webrtc.GatheringCompletePromise(station.peers[peerIndex].peerConnection)
+		station.mutex.Unlock()
+
+		for {
+			station.mutex.Lock()
+			err := station.peers[peerIndex].peerConnection.SetLocalDescription(localSessionDescription)
+			station.mutex.Unlock()
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr,
+					"while setting local description: %s\n",
+					err)
+				continue
+			}
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, "conn %d: waiting for all ice candidates\n", peerIndex)
+		<-waitForAllICECandidates
+
+		fmt.Fprintf(os.Stderr, "conn %d: all ice candidates are received from stun server\n", peerIndex)
+
+		var peerLocalSessionDescription *webrtc.SessionDescription
+		station.mutex.Lock()
+		peerLocalSessionDescription = station.peers[peerIndex].peerConnection.LocalDescription()
+		station.mutex.Unlock()
+
+		if peerType == PeerTypeHost {
+
+			fmt.Fprintf(os.Stderr, "conn %d: waiting for the signalling settlement\n", peerIndex)
+
+			guestAnswer, err := signalClient.WaitForGuest(signalCtx,
+				hostId,
+				peerIndex,
+				*peerLocalSessionDescription)
+			if err != nil {
+				station.mutex.Lock()
+				station.peers[peerIndex].Close(peerIndex)
+				station.mutex.Unlock()
+				continue
+			}
+
+			if err := verifyFingerprints(&guestAnswer, pinnedFingerprints); err != nil {
+				fmt.Fprintf(os.Stderr, "conn %d: refusing the guest - %s\n", peerIndex, err)
+				station.mutex.Lock()
+				station.peers[peerIndex].Close(peerIndex)
+				station.mutex.Unlock()
+
+				// the same guest may answer the next offer right away
+				select {
+				case <-signalCtx.Done():
+				case <-time.After(1 * time.Second):
+				}
+				continue
+			}
+
+			// debug logging
+			fmt.Fprintf(os.Stderr, "conn %d: setting the remote description\n", peerIndex)
+
+			station.mutex.Lock()
+			station.peers[peerIndex].peerConnection.SetRemoteDescription(guestAnswer)
+			station.mutex.Unlock()
+
+			// debug logging
+			fmt.Fprintf(os.Stderr, "conn %d: have set the remote description\n", peerIndex)
+		} else {
+			err := signalClient.GuestSetup(signalCtx,
+				hostId,
+				*peerLocalSessionDescription,
+				peerIndex)
+			if err != nil {
+				station.mutex.Lock()
+				station.peers[peerIndex].Close(peerIndex)
+				station.mutex.Unlock()
+				continue
+			}
+		}
+
+		station.mutex.Lock()
+		remoteSessionDescription := station.peers[peerIndex].peerConnection.RemoteDescription()
+		station.mutex.Unlock()
+
+		if remoteSessionDescription != nil {
+			sas, err := shortAuthenticationString(peerLocalSessionDescription, remoteSessionDescription)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "conn %d: short authentication string - %s\n", peerIndex, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "conn %d: short authentication string - %s\n", peerIndex, sas)
+			}
+		}
+
+		// a new room is for the next peer connection now, the guest still
+		// waits for a new host id while it is connected
+		if peerType == PeerTypeHost {
+			station.mutex.Lock()
+			station.cancelSignal = nil
+			station.mutex.Unlock()
+			cancelSignal()
+		}
+
+		fmt.Fprintf(os.Stderr, "conn %d: signalling settled: waiting for the ice connection\n", peerIndex)
+
+		select {
+		case connected := <-connectedChannel:
+			if connected {
+				fmt.Fprintf(os.Stderr, "conn %d: ice connected\n", peerIndex)
+				if peerType == PeerTypeGuest {
+					select {
+					case connected = <-connectedChannel:
+					case <-signalCtx.Done():
+						if ctx.Err() != nil {
+							station.mutex.Lock()
+							station.peers[peerIndex].Close(peerIndex)
+							station.mutex.Unlock()
+							return
+						}
+
+						// the host stays connected until it leaves
+						fmt.Fprintf(os.Stderr, "conn %d: host id changed, signalling starts over\n", peerIndex)
+						continue
+					}
+				}
+			}
+
+			if !connected {
+				fmt.Fprintf(os.Stderr, "conn %d: ice disconnected\n", peerIndex)
+			}
+		case <-time.After(30 * time.Second):
+			fmt.Fprintf(os.Stderr, "conn %d: timeout waiting for ice event\n", peerIndex)
+			station.mutex.Lock()
+			station.peers[peerIndex].Close(peerIndex)
+			station.mutex.Unlock()
+		case <-ctx.Done():
+			// the peer may have come after Run closed the others
+			station.mutex.Lock()
+			station.peers[peerIndex].Close(peerIndex)
+			station.mutex.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package station
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	webhookAttempts     = 5
+	webhookFirstBackoff = 1 * time.Second
+	// events that came while the webhook is down wait here, the newer
+	// ones are dropped
+	webhookQueueLength = 256
+)
+
+// POSTs the events of the station as JSON, one at a time and in order, and
+// tries each again with a growing pause while the receiver fails.
+type webhook struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+func newWebhook(url string, token string) *webhook {
+	return &webhook{
+		url:        url,
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (hook *webhook) run(ctx context.Context, events <-chan Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			hook.deliver(ctx, event)
+		}
+	}
+}
+
+func (hook *webhook) deliver(ctx context.Context, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		panic(fmt.Sprintf("logic: marshal event - %s", err))
+	}
+
+	backoff := webhookFirstBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := hook.post(ctx, body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt == webhookAttempts {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: webhook - giving up on the %s event: %s\n",
+				event.Peer,
+				event.Type,
+				err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Whether a failed POST is worth another try, the receiver refusing the
+// event is not.
+func (hook *webhook) post(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if hook.token != "" {
+		request.Header.Set("Authorization", "Bearer "+hook.token)
+	}
+
+	response, err := hook.httpClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return true, fmt.Errorf("response status %s", response.Status)
+	default:
+		return false, fmt.Errorf("response status %s", response.Status)
+	}
+}
@@ -0,0 +1,745 @@
+package station
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+type PeerOptions struct {
+	// DTLS certificates shared by every peer connection, so that the
+	// station keeps its fingerprint. Empty generates one per connection.
+	Certificates []webrtc.Certificate
+	// sendrecv, sendonly for a camera that takes no media from the peers
+	// or recvonly for a viewer without local media
+	Direction webrtc.RTPTransceiverDirection
+	// the media of the sessions, at least one of them
+	Audio bool
+	Video bool
+	// codecs of the local tracks, taken from the media sources
+	AudioCodec webrtc.RTPCodecCapability
+	VideoCodec webrtc.RTPCodecCapability
+	// the ids the browsers see, the tracks are grouped into one media
+	// stream by its id
+	StreamID     string
+	AudioTrackID string
+	VideoTrackID string
+	// SSRCs of the local tracks for every peer, 0 lets pion pick one per
+	// peer connection
+	AudioSSRC webrtc.SSRC
+	VideoSSRC webrtc.SSRC
+	// local tracks bound to all the peer connections, nil gives every
+	// peer tracks of its own
+	SharedAudioTrack *webrtc.TrackLocalStaticRTP
+	SharedVideoTrack *webrtc.TrackLocalStaticRTP
+	// encodings of the local video, best first, every peer gets one
+	VideoLayers []*videoLayer
+	// local videos besides the camera, like a screen share
+	ExtraVideos []*extraVideo
+	// the ones added while the station runs, all shared, guarded by the
+	// mutex of the peers and replaced instead of changed
+	addedVideos []*extraVideo
+	// where the media of the guest on air goes, see media.NewSink
+	AudioSinks []string
+	VideoSinks []string
+	// where the remote videos go whose track id has sinks of their own,
+	// by track id, the other videos go to VideoSinks
+	ExtraVideoSinks map[string][]string
+	// what the sinks of all the peers share
+	SinkOptions media.SinkOptions
+	// a peer whose send queue stays full this long is disconnected, 0
+	// keeps it however far behind
+	SlowPeerTimeout time.Duration
+	// gives way when its offer crosses one of the other side, the guest
+	// is polite and the host is not
+	Polite bool
+	// how long the remote tracks wait for a missing packet before the
+	// sinks get the packets after it, 0 passes them on as they come
+	JitterBufferLatency time.Duration
+	// what happens to the peers goes there
+	events *eventBus
+	// let a guest go on air by asking over the data channel
+	OnAirRequests bool
+	// whose remote media goes to the sinks
+	onAir *onAirSelector
+}
+
+// The kinds of the media of the sessions.
+func (options *PeerOptions) kinds() []webrtc.RTPCodecType {
+	var kinds []webrtc.RTPCodecType
+	if options.Video {
+		kinds = append(kinds, webrtc.RTPCodecTypeVideo)
+	}
+	if options.Audio {
+		kinds = append(kinds, webrtc.RTPCodecTypeAudio)
+	}
+
+	return kinds
+}
+
+func startPeerConnection(options *PeerOptions) (
+	*webrtc.PeerConnection,
+	*webrtc.TrackLocalStaticRTP,
+	*webrtc.TrackLocalStaticRTP,
+	[]*webrtc.TrackLocalStaticRTP,
+	*webrtc.DataChannel,
+	cc.BandwidthEstimator,
+	error) {
+
+	api, estimatorChannel, err := newEstimatingAPI()
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, err
+	}
+
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{
+			{
+				URLs: []string{"stun:stun.l.google.com:19302"},
+			},
+		},
+		Certificates: options.Certificates,
+	})
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, err
+	}
+
+	var videoTrack, audioTrack *webrtc.TrackLocalStaticRTP
+	var extraVideoTracks []*webrtc.TrackLocalStaticRTP
+
+	if options.Direction == webrtc.RTPTransceiverDirectionRecvonly {
+		// nothing to send, the peer only offers or answers to receive
+		for _, kind := range options.kinds() {
+			_, err = peerConnection.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			})
+			if err != nil {
+				peerConnection.Close()
+				return nil, nil, nil, nil, nil, nil, err
+			}
+		}
+	} else {
+		if options.Video {
+			videoTrack = options.SharedVideoTrack
+			if videoTrack == nil {
+				videoTrack, err = webrtc.NewTrackLocalStaticRTP(
+					options.VideoCodec,
+					options.VideoTrackID,
+					options.StreamID)
+
+				if err != nil {
+					peerConnection.Close()
+					return nil, nil, nil, nil, nil, nil, err
+				}
+			}
+
+			_, err = peerConnection.AddTransceiverFromTrack(videoTrack,
+				localTrackInit(options.Direction, options.VideoSSRC))
+			if err != nil {
+				peerConnection.Close()
+				return nil, nil, nil, nil, nil, nil, err
+			}
+
+			for _, extra := range options.ExtraVideos {
+				extraTrack := extra.shared
+				if extraTrack == nil {
+					extraTrack, err = webrtc.NewTrackLocalStaticRTP(
+						extra.codec,
+						extra.name,
+						options.StreamID)
+
+					if err != nil {
+						peerConnection.Close()
+						return nil, nil, nil, nil, nil, nil, err
+					}
+				}
+
+				_, err = peerConnection.AddTransceiverFromTrack(extraTrack,
+					localTrackInit(options.Direction, extra.ssrc))
+				if err != nil {
+					peerConnection.Close()
+					return nil, nil, nil, nil, nil, nil, err
+				}
+				extraVideoTracks = append(extraVideoTracks, extraTrack)
+			}
+		}
+
+		if options.Audio {
+			audioTrack = options.SharedAudioTrack
+			if audioTrack == nil {
+				audioTrack, err = webrtc.NewTrackLocalStaticRTP(
+					options.AudioCodec,
+					options.AudioTrackID,
+					options.StreamID)
+
+				if err != nil {
+					peerConnection.Close()
+					return nil, nil, nil, nil, nil, nil, err
+				}
+			}
+
+			_, err = peerConnection.AddTransceiverFromTrack(audioTrack,
+				localTrackInit(options.Direction, options.AudioSSRC))
+			if err != nil {
+				peerConnection.Close()
+				return nil, nil, nil, nil, nil, nil, err
+			}
+		}
+	}
+
+	// // Read incoming RTCP packets
+	// // Before these packets are returned they are processed by interceptors. For things
+	// // like NACK this needs to be called.
+	// go func() {
+	// 	rtcpBuf := make([]byte, 1500)
+	// 	for {
+	// 		if _, _, rtcpErr := rtpSender.Read(rtcpBuf); rtcpErr != nil {
+	// 			return
+	// 		}
+	// 	}
+	// }()
+
+	// room for the remote videos with sinks of their own, the
+	// transceivers of the local ones take them too
+	if options.Video && options.Direction != webrtc.RTPTransceiverDirectionSendonly {
+		for range len(options.ExtraVideoSinks) - len(extraVideoTracks) {
+			_, err = peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			})
+			if err != nil {
+				peerConnection.Close()
+				return nil, nil, nil, nil, nil, nil, err
+			}
+		}
+	}
+
+	dataChannelOrdered := true
+	dataChannelNegotiated := true
+	var dataChannelID uint16 = 0
+	dataChannelInit := webrtc.DataChannelInit{
+		Ordered:    &dataChannelOrdered,
+		Negotiated: &dataChannelNegotiated,
+		ID:         &dataChannelID,
+	}
+	dataChannel, err := peerConnection.CreateDataChannel(
+		"meetupstation", &dataChannelInit)
+	if err != nil {
+		peerConnection.Close()
+		return nil, nil, nil, nil, nil, nil, err
+	}
+
+	// dataChannel.OnOpen(func() {
+	// 	fmt.Fprintf(os.Stderr,
+	// 		"data channel opened\n")
+	// })
+	// dataChannel.OnClose(func() {
+	// 	fmt.Fprintf(os.Stderr,
+	// 		"data channel closed\n")
+	// })
+
+	// the congestion controller is made with the peer connection
+	bandwidthEstimator := <-estimatorChannel
+
+	return peerConnection,
+		videoTrack,
+		audioTrack,
+		extraVideoTracks,
+		dataChannel,
+		bandwidthEstimator,
+		nil
+}
+
+// Send an added video to a peer connection, the track is shared.
+func addVideo(peerConnection *webrtc.PeerConnection,
+	direction webrtc.RTPTransceiverDirection,
+	added *extraVideo) (*webrtc.RTPSender, error) {
+
+	transceiver, err := peerConnection.AddTransceiverFromTrack(added.shared,
+		localTrackInit(direction, added.ssrc))
+	if err != nil {
+		return nil, err
+	}
+
+	return transceiver.Sender(), nil
+}
+
+// Like AddTrack, with the SSRC of the station when there is one, so that
+// a peer that connects again finds the same stream. Sendonly does not
+// take media from the peer.
+func localTrackInit(direction webrtc.RTPTransceiverDirection, ssrc webrtc.SSRC) webrtc.RTPTransceiverInit {
+	if direction != webrtc.RTPTransceiverDirectionSendonly {
+		direction = webrtc.RTPTransceiverDirectionSendrecv
+	}
+
+	init := webrtc.RTPTransceiverInit{
+		Direction: direction,
+	}
+
+	if ssrc != 0 {
+		init.SendEncodings = []webrtc.RTPEncodingParameters{
+			{RTPCodingParameters: webrtc.RTPCodingParameters{SSRC: ssrc}},
+		}
+	}
+
+	return init
+}
+
+func newPeerConnection(peers *[]Peer,
+	mutex *sync.Mutex,
+	options *PeerOptions) (
+	int,
+	chan bool) {
+	for {
+		peerConnection,
+			localVideoTrack,
+			localAudioTrack,
+			localExtraVideoTracks,
+			dataChannel,
+			bandwidthEstimator,
+			err := startPeerConnection(options)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"while setting up peer connection. will retry: %s\n",
+				err)
+			continue
+		}
+
+		mutex.Lock()
+		videoLayers := newLayerSelector(len(*peers), options.VideoLayers, options.VideoCodec)
+		peerIndex := len(*peers)
+		addedVideoSenders := map[string]*webrtc.RTPSender{}
+		for _, added := range options.addedVideos {
+			rtpSender, err := addVideo(peerConnection, options.Direction, added)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "conn %d: adding the %s track - %s\n", peerIndex, added.name, err)
+				continue
+			}
+			addedVideoSenders[added.name] = rtpSender
+		}
+		var sender *peerSender
+		if localVideoTrack != nil || localAudioTrack != nil {
+			sender = newPeerSender(peerIndex,
+				localAudioTrack, localVideoTrack, localExtraVideoTracks, videoLayers,
+				options.SlowPeerTimeout,
+				func() {
+					mutex.Lock()
+					(*peers)[peerIndex].Close(peerIndex)
+					mutex.Unlock()
+				})
+		}
+		*peers = append(*peers, Peer{
+			peerConnection:   peerConnection,
+			localVideoTrack:  localVideoTrack,
+			localAudioTrack:  localAudioTrack,
+			dataChannel:      dataChannel,
+			remoteVideoSinks: nil,
+			remoteAudioSinks: nil,
+			videoLayers:      videoLayers,
+			sender:           sender,
+
+			bandwidthEstimator:    bandwidthEstimator,
+			localExtraVideoTracks: localExtraVideoTracks,
+			addedVideoSenders:     addedVideoSenders,
+			onClose: []func(){func() {
+				if (*peers)[peerIndex].joined {
+					options.emit(Event{Type: EventPeerLeft, Peer: peerIndex})
+				}
+				options.onAir.remove(peerIndex)
+			}},
+		})
+		mutex.Unlock()
+
+		bandwidthEstimator.OnTargetBitrateChange(func(bitrate int) {
+			videoLayers.setSendSideEstimate(uint64(bitrate))
+		})
+
+		for _, sender := range peerConnection.GetSenders() {
+			go readFeedback(sender, videoLayers)
+		}
+
+		// room for the up and the down of the connection, the callback
+		// never waits for a reader that has given up on the peer
+		connectedChannel := make(chan bool, 2)
+		var channelMutex sync.Mutex
+		var up, down bool
+
+		peerConnection.OnICEConnectionStateChange(
+			func(connectionState webrtc.ICEConnectionState) {
+				fmt.Fprintf(os.Stderr,
+					"conn %d: state - %s\n",
+					peerIndex,
+					connectionState.String())
+				options.emit(Event{
+					Type:  EventICEStateChanged,
+					Peer:  peerIndex,
+					State: connectionState.String(),
+				})
+
+				channelMutex.Lock()
+				defer channelMutex.Unlock()
+
+				switch connectionState {
+				case webrtc.ICEConnectionStateConnected:
+					if up || down {
+						return
+					}
+					up = true
+					connectedChannel <- true
+
+					go func() {
+						mutex.Lock()
+						(*peers)[peerIndex].connected(peerIndex, options)
+						mutex.Unlock()
+					}()
+				case webrtc.ICEConnectionStateFailed,
+					webrtc.ICEConnectionStateDisconnected,
+					webrtc.ICEConnectionStateClosed:
+					if !down {
+						down = true
+						connectedChannel <- false
+						close(connectedChannel)
+					}
+
+					// this runs inside Close too, under the mutex
+					go func() {
+						mutex.Lock()
+						(*peers)[peerIndex].Close(peerIndex)
+						mutex.Unlock()
+					}()
+				}
+			})
+
+		return len(*peers) - 1, connectedChannel
+	}
+}
+
+// Answer a remote offer in one go and wait for all candidates, for the
+// endpoints without trickle ICE.
+func answerOffer(peers *[]Peer,
+	mutex *sync.Mutex,
+	peerIndex int,
+	offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+
+	mutex.Lock()
+	peerConnection := (*peers)[peerIndex].peerConnection
+	mutex.Unlock()
+
+	if peerConnection == nil {
+		return nil, errors.New("peer connection is closed")
+	}
+
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return nil, err
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	waitForAllICECandidates := webrtc.GatheringCompletePromise(peerConnection)
+
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-waitForAllICECandidates:
+	case <-time.After(10 * time.Second):
+		return nil, errors.New("timeout gathering ice candidates")
+	}
+
+	return peerConnection.LocalDescription(), nil
+}
+
+func setupTracksAndDataHandlers(peers *[]Peer, peerIndex int, options *PeerOptions) {
+	// goes on air once it is connected
+	(*peers)[peerIndex].guest = true
+
+	router := newTrackRouter(peerIndex, options.ExtraVideoSinks)
+
+	(*peers)[peerIndex].peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		// a guest offering media the station does not take
+		if (track.Kind() == webrtc.RTPCodecTypeVideo && !options.Video) ||
+			(track.Kind() == webrtc.RTPCodecTypeAudio && !options.Audio) {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: ignoring the %s track, not a media of the station\n",
+				peerIndex,
+				track.Kind())
+			return
+		}
+
+		sinksName := router.take(track)
+		if sinksName == "" {
+			return
+		}
+		defer router.release(sinksName)
+
+		requestKeyframe := func() {
+			mediaSSRC := uint32(track.SSRC())
+			_, err := receiver.Transport().WriteRTCP([]rtcp.Packet{
+				&rtcp.PictureLossIndication{MediaSSRC: mediaSSRC},
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr,
+					"conn %d: keyframe request - %s\n",
+					peerIndex,
+					err)
+			}
+		}
+
+		bindSinks := func(sinks []media.Sink) []media.Sink {
+			boundSinks := make([]media.Sink, 0, len(sinks))
+			for _, sink := range sinks {
+				if requester, ok := sink.(media.KeyframeRequester); ok && track.Kind() == webrtc.RTPCodecTypeVideo {
+					requester.OnKeyframeNeeded(requestKeyframe)
+				}
+
+				if err := sink.Bind(track.Codec()); err != nil {
+					fmt.Fprintf(os.Stderr,
+						"conn %d: %s sink bind - %s\n",
+						peerIndex,
+						track.Kind(),
+						err)
+					continue
+				}
+				boundSinks = append(boundSinks, sink)
+
+				if labeler, ok := sink.(media.TrackLabeler); ok {
+					labeler.LabelTrack(trackLabel(track))
+				}
+			}
+
+			// the sinks go on with the stream of the guest on air, a
+			// keyframe lets their decoders move to it right away
+			if len(boundSinks) != 0 && track.Kind() == webrtc.RTPCodecTypeVideo {
+				requestKeyframe()
+			}
+
+			return boundSinks
+		}
+
+		options.emit(Event{Type: EventTrackStarted, Peer: peerIndex, Track: trackLabel(track)})
+
+		// the own path of the peer on the rtsp server plays it whether it
+		// is on air or not
+		var peerSinks []media.Sink
+		if rtspServer := options.SinkOptions.RTSPServer; rtspServer != nil &&
+			(sinksName == "audio" || sinksName == "video") {
+
+			mediaType := media.Audio
+			if track.Kind() == webrtc.RTPCodecTypeVideo {
+				mediaType = media.Video
+			}
+
+			peerSinks = bindSinks([]media.Sink{media.NewRTSPPeerSink(rtspServer, mediaType, peerIndex)})
+			defer closeSinks(peerIndex, "rtsp "+sinksName, peerSinks)
+		}
+
+		var buffer *media.JitterBuffer
+		if options.JitterBufferLatency > 0 {
+			buffer = media.NewJitterBuffer(peerIndex, track.Kind().String(), options.JitterBufferLatency)
+		}
+
+		// the sinks of the track while the peer is on air, taken again
+		// whenever another guest goes on air
+		var onAirSinks, sinks []media.Sink
+		var onAirGeneration uint64
+		fetched := false
+
+		// the jitter buffer lets go of a gap at its deadline, a packet
+		// or not
+		var readDeadline time.Time
+
+		for {
+			if buffer != nil && !buffer.Deadline().Equal(readDeadline) {
+				readDeadline = buffer.Deadline()
+				track.SetReadDeadline(readDeadline)
+			}
+
+			rtpPacket, _, err := track.ReadRTP()
+
+			var netError net.Error
+			if buffer != nil && errors.As(err, &netError) && netError.Timeout() {
+				for _, rtpPacket := range buffer.Release(time.Now()) {
+					sinks = writeSinks(peerIndex, sinks, rtpPacket)
+					peerSinks = writeSinks(peerIndex, peerSinks, rtpPacket)
+				}
+				continue
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr,
+					"conn %d: track read - %s\n",
+					peerIndex,
+					err)
+				break
+			}
+
+			if generation := options.onAir.generation.Load(); !fetched || generation != onAirGeneration {
+				fetched, onAirGeneration = true, generation
+
+				current := options.onAir.sinks(peerIndex, sinksName)
+				if !slices.Equal(current, onAirSinks) {
+					onAirSinks = current
+					sinks = bindSinks(current)
+				}
+			}
+
+			rtpPackets := []*rtp.Packet{rtpPacket}
+			if buffer != nil {
+				rtpPackets = buffer.Push(rtpPacket)
+			}
+
+			for _, rtpPacket := range rtpPackets {
+				sinks = writeSinks(peerIndex, sinks, rtpPacket)
+				peerSinks = writeSinks(peerIndex, peerSinks, rtpPacket)
+			}
+		}
+	})
+
+	(*peers)[peerIndex].dataChannel.OnClose(func() {
+	})
+
+	renegotiator := newRenegotiator(peerIndex,
+		(*peers)[peerIndex].peerConnection,
+		(*peers)[peerIndex].dataChannel,
+		options)
+
+	(*peers)[peerIndex].dataChannel.OnMessage(func(message webrtc.DataChannelMessage) {
+		if renegotiator.handleMessage(message) {
+			return
+		}
+		if options.onAir.handleMessage(peerIndex, message) {
+			return
+		}
+
+		fmt.Fprintf(os.Stderr,
+			"conn %d: data - %s\n",
+			peerIndex,
+			string(message.Data))
+		options.emit(Event{Type: EventDataMessage, Peer: peerIndex, Data: string(message.Data)})
+	})
+}
+
+// Write a packet to all the sinks, returns the sinks that took it.
+func writeSinks(peerIndex int, sinks []media.Sink, packet *rtp.Packet) []media.Sink {
+	for sinkIndex := 0; sinkIndex < len(sinks); {
+		err := sinks[sinkIndex].WriteRTP(packet)
+		if err != nil {
+			// the peer closes the sink later, it only stops getting
+			// packets here
+			fmt.Fprintf(os.Stderr,
+				"conn %d: rtp packet write - %s\n",
+				peerIndex,
+				err)
+			sinks = append(sinks[:sinkIndex], sinks[sinkIndex+1:]...)
+			continue
+		}
+		sinkIndex++
+	}
+
+	return sinks
+}
+
+func openSinks(peerIndex int, mediaType media.Type, specs []string, options *PeerOptions) []media.Sink {
+	sinks := []media.Sink{}
+
+	for _, spec := range specs {
+		sink, err := media.NewSink(mediaType, spec, peerIndex, options.SinkOptions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr,
+				"conn %d: sink %s - %s\n",
+				peerIndex,
+				spec,
+				err)
+			continue
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks
+}
+
+// Send the packets of a local source to all peers. The video can have
+// several layers, each streamed by its own call, layerIndex is the index of
+// layer in PeerOptions.VideoLayers. extraIndex is the index of an extra
+// video in PeerOptions.ExtraVideos, -1 for the camera and the audio. A
+// shared track goes to all the peers with a single write.
+func streamLocalTrack(ctx context.Context,
+	peers *[]Peer,
+	mutex *sync.Mutex,
+	mediaType media.Type,
+	layer *videoLayer,
+	layerIndex int,
+	extraIndex int,
+	shared *webrtc.TrackLocalStaticRTP,
+	mute *trackMute,
+	source media.Source) {
+	gate := newMuteGate(mute, mediaType, source.Codec())
+
+	// closing the source ends a read that waits for a packet
+	go func() {
+		<-ctx.Done()
+		if err := source.Close(); err != nil {
+			fmt.Fprintf(os.Stderr,
+				"source.Close, %s\n",
+				err)
+		}
+	}()
+
+	var senders []*peerSender
+
+	for ctx.Err() == nil {
+		packet := newLocalPacket()
+
+		err := readLocalPacket(source, packet)
+		if err != nil {
+			packet.release()
+
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			fmt.Fprintf(os.Stderr,
+				"source.ReadRTP: %s\n",
+				err)
+			continue
+		}
+
+		if !gate.pass(&packet.packet) {
+			packet.release()
+			continue
+		}
+
+		if layer != nil {
+			layer.measure(&packet.packet)
+		}
+
+		if shared != nil {
+			// the closed peer connections unbind themselves
+			if err = shared.WriteRTP(&packet.packet); err != nil {
+				fmt.Fprintf(os.Stderr,
+					"while write to shared track: %s\n",
+					err)
+			}
+			packet.release()
+			continue
+		}
+
+		senders = fanOut(peers, mutex, senders, mediaType, layerIndex, extraIndex, packet)
+		packet.release()
+	}
+}
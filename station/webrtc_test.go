@@ -0,0 +1,49 @@
+package station
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"meetupstation/meetupstation-pion/media"
+)
+
+// A generated source never fails a read, only the cancel can stop it.
+func TestStreamLocalTrackStopsOnCancel(t *testing.T) {
+	for name, mediaType := range map[string]media.Type{"video": media.Video, "audio": media.Audio} {
+		t.Run(name, func(t *testing.T) {
+			source, err := media.NewSource(mediaType, "test", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			track, err := webrtc.NewTrackLocalStaticRTP(source.Codec(), name, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var peers []Peer
+			var mutex sync.Mutex
+			ctx, cancel := context.WithCancel(context.Background())
+
+			done := make(chan struct{})
+			go func() {
+				streamLocalTrack(ctx, &peers, &mutex, mediaType, nil, 0, -1, track, nil, source)
+				close(done)
+			}()
+
+			// a few frames go out first
+			time.Sleep(200 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("streamLocalTrack still running after the cancel")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package station
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Lets a WHEP player watch the local feed without the signalling server.
+// The viewer becomes a peer that only gets the local tracks, it does not
+// go on air like a guest and the guest stays connected.
+type whepServer struct {
+	peers   *[]Peer
+	mutex   *sync.Mutex
+	options *PeerOptions
+	// bearer token the player has to send, empty allows everyone
+	token     string
+	resources *peerResources
+}
+
+func newWHEPServer(peers *[]Peer,
+	mutex *sync.Mutex,
+	options *PeerOptions,
+	token string) *whepServer {
+
+	return &whepServer{
+		peers:     peers,
+		mutex:     mutex,
+		options:   options,
+		token:     token,
+		resources: newPeerResources(peers, mutex, "/whep", "whep viewer"),
+	}
+}
+
+func (server *whepServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("OPTIONS /whep", server.preflight)
+	mux.HandleFunc("POST /whep", server.play)
+	mux.HandleFunc("OPTIONS /whep/{id}", server.preflight)
+	mux.HandleFunc("DELETE /whep/{id}", server.teardown)
+}
+
+func (server *whepServer) preflight(writer http.ResponseWriter, request *http.Request) {
+	allowCrossOrigin(writer)
+	writer.Header().Set("Accept-Post", "application/sdp")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *whepServer) play(writer http.ResponseWriter, request *http.Request) {
+	allowCrossOrigin(writer)
+
+	if !authorized(request, server.token) {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// the player would get an empty session
+	if server.options.Direction == webrtc.RTPTransceiverDirectionRecvonly {
+		http.Error(writer, "the station is recvonly, it has no media to play", http.StatusForbidden)
+		return
+	}
+	if !server.options.Video && !server.options.Audio {
+		http.Error(writer, "the station has no media to play", http.StatusForbidden)
+		return
+	}
+
+	offer, ok := readOffer(writer, request)
+	if !ok {
+		return
+	}
+
+	// the local tracks of the new peer are part of the streamLocalTrack
+	// fan-out as soon as it is in the peers
+	peerIndex, connectedChannel := newPeerConnection(server.peers, server.mutex, server.options)
+	go drainConnectedChannel(connectedChannel)
+
+	fmt.Fprintf(os.Stderr, "conn %d: whep viewer\n", peerIndex)
+
+	answer, err := answerOffer(server.peers, server.mutex, peerIndex, offer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "conn %d: whep - %s\n", peerIndex, err)
+		server.mutex.Lock()
+		(*server.peers)[peerIndex].Close(peerIndex)
+		server.mutex.Unlock()
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server.resources.add(writer, peerIndex, answer)
+}
+
+func (server *whepServer) teardown(writer http.ResponseWriter, request *http.Request) {
+	allowCrossOrigin(writer)
+
+	if !authorized(request, server.token) {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server.resources.remove(writer, request)
+}
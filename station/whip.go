@@ -0,0 +1,237 @@
+package station
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Lets a WHIP publisher like OBS or a browser send straight to the
+// station. The publisher is a guest like the ones from the signalling
+// server, its media goes to the sinks while it is on air.
+type whipServer struct {
+	peers   *[]Peer
+	mutex   *sync.Mutex
+	options *PeerOptions
+	// bearer token the publisher has to send, empty allows everyone
+	token     string
+	resources *peerResources
+}
+
+func newWHIPServer(peers *[]Peer,
+	mutex *sync.Mutex,
+	options *PeerOptions,
+	token string) *whipServer {
+
+	return &whipServer{
+		peers:     peers,
+		mutex:     mutex,
+		options:   options,
+		token:     token,
+		resources: newPeerResources(peers, mutex, "/whip", "whip publisher"),
+	}
+}
+
+func (server *whipServer) register(mux *http.ServeMux) {
+	mux.HandleFunc("OPTIONS /whip", server.preflight)
+	mux.HandleFunc("POST /whip", server.publish)
+	mux.HandleFunc("OPTIONS /whip/{id}", server.preflight)
+	mux.HandleFunc("DELETE /whip/{id}", server.teardown)
+}
+
+func (server *whipServer) preflight(writer http.ResponseWriter, request *http.Request) {
+	allowCrossOrigin(writer)
+	writer.Header().Set("Accept-Post", "application/sdp")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (server *whipServer) publish(writer http.ResponseWriter, request *http.Request) {
+	allowCrossOrigin(writer)
+
+	if !authorized(request, server.token) {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// the publisher sends media the station would not take
+	if server.options.Direction == webrtc.RTPTransceiverDirectionSendonly {
+		http.Error(writer, "the station is sendonly, it takes no published media", http.StatusForbidden)
+		return
+	}
+
+	offer, ok := readOffer(writer, request)
+	if !ok {
+		return
+	}
+
+	peerIndex, connectedChannel := newPeerConnection(server.peers, server.mutex, server.options)
+	go drainConnectedChannel(connectedChannel)
+
+	server.mutex.Lock()
+	fmt.Fprintf(os.Stderr, "conn %d: whip publisher, setting up tracks and data handlers\n", peerIndex)
+	setupTracksAndDataHandlers(server.peers, peerIndex, server.options)
+	server.mutex.Unlock()
+
+	answer, err := answerOffer(server.peers, server.mutex, peerIndex, offer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "conn %d: whip - %s\n", peerIndex, err)
+		server.mutex.Lock()
+		(*server.peers)[peerIndex].Close(peerIndex)
+		server.mutex.Unlock()
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	server.resources.add(writer, peerIndex, answer)
+}
+
+func (server *whipServer) teardown(writer http.ResponseWriter, request *http.Request) {
+	allowCrossOrigin(writer)
+
+	if !authorized(request, server.token) {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	server.resources.remove(writer, request)
+}
+
+// Helpers shared by the WHIP and WHEP endpoints.
+
+func allowCrossOrigin(writer http.ResponseWriter) {
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.Header().Set("Access-Control-Allow-Methods", "OPTIONS, POST, DELETE")
+	writer.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	writer.Header().Set("Access-Control-Expose-Headers", "Location")
+}
+
+func authorized(request *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+
+	bearer, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+
+	return found && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+func readOffer(writer http.ResponseWriter, request *http.Request) (webrtc.SessionDescription, bool) {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/sdp" {
+		http.Error(writer, "expecting application/sdp", http.StatusUnsupportedMediaType)
+		return webrtc.SessionDescription{}, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(request.Body, 64*1024))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return webrtc.SessionDescription{}, false
+	}
+
+	return webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(body),
+	}, true
+}
+
+func writeAnswer(writer http.ResponseWriter, location string, answer *webrtc.SessionDescription) {
+	writer.Header().Set("Content-Type", "application/sdp")
+	writer.Header().Set("Location", location)
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write([]byte(answer.SDP))
+}
+
+func newResourceID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("logic: rand.Read - %s", err))
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// The resources of the peers of an endpoint, each goes away with its peer
+// or with a DELETE of its location.
+type peerResources struct {
+	peers *[]Peer
+	mutex *sync.Mutex
+	// the endpoint, the locations are under it
+	path string
+	// who the peers are, for the log
+	role string
+
+	resourcesMutex sync.Mutex
+	// resource id to peer index
+	resources map[string]int
+}
+
+func newPeerResources(peers *[]Peer, mutex *sync.Mutex, path string, role string) *peerResources {
+	return &peerResources{
+		peers:     peers,
+		mutex:     mutex,
+		path:      path,
+		role:      role,
+		resources: map[string]int{},
+	}
+}
+
+// Make the peer a resource and reply with the answer and its location.
+func (resources *peerResources) add(writer http.ResponseWriter, peerIndex int, answer *webrtc.SessionDescription) {
+	resource := newResourceID()
+
+	// the resource goes away with the peer, however it is closed
+	resources.mutex.Lock()
+	peer := &(*resources.peers)[peerIndex]
+	if peer.peerConnection != nil {
+		resources.resourcesMutex.Lock()
+		resources.resources[resource] = peerIndex
+		resources.resourcesMutex.Unlock()
+
+		peer.onClose = append(peer.onClose, func() {
+			resources.resourcesMutex.Lock()
+			delete(resources.resources, resource)
+			resources.resourcesMutex.Unlock()
+		})
+	}
+	resources.mutex.Unlock()
+
+	writeAnswer(writer, resources.path+"/"+resource, answer)
+}
+
+// Close the peer of the resource in the path of a DELETE.
+func (resources *peerResources) remove(writer http.ResponseWriter, request *http.Request) {
+	resource := request.PathValue("id")
+
+	resources.resourcesMutex.Lock()
+	peerIndex, found := resources.resources[resource]
+	delete(resources.resources, resource)
+	resources.resourcesMutex.Unlock()
+
+	if !found {
+		http.NotFound(writer, request)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "conn %d: %s left\n", peerIndex, resources.role)
+
+	resources.mutex.Lock()
+	(*resources.peers)[peerIndex].Close(peerIndex)
+	resources.mutex.Unlock()
+
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Nobody waits for the ICE events of the http peers.
+func drainConnectedChannel(connectedChannel chan bool) {
+	for range connectedChannel {
+	}
+}